@@ -1,9 +1,13 @@
 package cmdline
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/some-programs/transmission-showrss/pkg/showrss"
 )
@@ -26,11 +30,17 @@ func TransmissionConfigFlags(fs *flag.FlagSet) *TransmissionConfig {
 func FeedSelectionFlags(fs *flag.FlagSet) *showrss.FeedSelection {
 	// FeedSelectionFlags .
 	v := &showrss.FeedSelection{
-		Shows: make([]int, 0),
-		Users: make([]int, 0),
+		Shows: make([]showrss.Feed, 0),
+		Users: make([]showrss.Feed, 0),
 	}
-	fs.Var((*intSliceFlag)(&v.Users), "users", "showrss user id's, comma separated")
-	fs.Var((*intSliceFlag)(&v.Shows), "shows", "showrss show id's, comma separated")
+	fs.Var(&feedSliceFlag{feeds: &v.Users, typ: showrss.FeedTypeUser}, "users",
+		"showrss user id's, comma separated. Each id can be followed by a quality and fallback chain: id[:quality[>quality@delay...]], e.g. 123:fhd>hd@6h")
+	fs.Var(&feedSliceFlag{feeds: &v.Shows, typ: showrss.FeedTypeShow}, "shows",
+		"showrss show id's, comma separated. Each id can be followed by a quality and fallback chain: id[:quality[>quality@delay...]], e.g. 123:fhd>hd@6h")
+	fs.Var(&urlFeedFlag{feeds: &v.URLFeeds, typ: showrss.FeedTypeRSS}, "rss",
+		"generic rss or atom feed: [show name=]url, can be repeated")
+	fs.Var(&urlFeedFlag{feeds: &v.URLFeeds, typ: showrss.FeedTypeTorznab}, "torznab",
+		"torznab indexer feed: [show name=]url, can be repeated")
 	return v
 }
 
@@ -41,27 +51,354 @@ func ShowDirsFlags(fs *flag.FlagSet) *showrss.ShowDirs {
 	return v
 }
 
-// intSliceFlag is a flag type which
-type intSliceFlag []int
+func APIConfigFlags(fs *flag.FlagSet) *showrss.APIConfig {
+	v := &showrss.APIConfig{}
+	fs.StringVar(&v.Addr, "api.addr", "", "listen address of the api server which also serves /metrics, /healthz and /readyz, e.g. :8384. The api server is disabled if empty")
+	fs.StringVar(&v.TLSCert, "api.tlscert", "", "tls certificate file of the api server, enables https together with -api.tlskey")
+	fs.StringVar(&v.TLSKey, "api.tlskey", "", "tls private key file of the api server")
+	fs.Var((*stringSliceFlag)(&v.ReadTokens), "api.readtokens", "bearer tokens with read only api access, comma separated. The dashboard passes its token to the event stream as the access_token query parameter, which may be logged by proxies")
+	fs.Var((*stringSliceFlag)(&v.AdminTokens), "api.admintokens", "bearer tokens with full api access, comma separated. See -api.readtokens about the event stream")
+	fs.Var((*stringSliceFlag)(&v.ReadUsers), "api.readusers", "basic auth user:password pairs with read only api access, comma separated")
+	fs.Var((*stringSliceFlag)(&v.AdminUsers), "api.adminusers", "basic auth user:password pairs with full api access, comma separated")
+	return v
+}
+
+func HTTPConfigFlags(fs *flag.FlagSet) *showrss.HTTPConfig {
+	v := &showrss.HTTPConfig{}
+	fs.StringVar(&v.UserAgent, "http.useragent", "transmission-showrss", "User-Agent header of feed requests")
+	fs.StringVar(&v.Proxy, "http.proxy", "", "proxy url for feed requests (http, https or socks5), defaults to the proxy environment variables")
+	fs.DurationVar(&v.Timeout, "http.timeout", 15*time.Second, "timeout of a single feed request")
+	fs.Int64Var(&v.MaxBodySize, "http.maxbodysize", 10<<20, "max feed response size in bytes")
+	return v
+}
+
+func RecordConfigFlags(fs *flag.FlagSet) *showrss.RecordConfig {
+	v := &showrss.RecordConfig{}
+	fs.StringVar(&v.RecordDir, "record.dir", "", "record all raw feed responses to this directory")
+	fs.DurationVar(&v.RecordRetention, "record.retention", 7*24*time.Hour, "remove recorded feed responses older than this, 0 keeps them forever")
+	fs.StringVar(&v.ReplayDir, "replay.dir", "", "replay feed responses recorded to this directory instead of fetching feeds from the network")
+	fs.DurationVar(&v.ReplayTTL, "replay.ttl", 0, "poll interval while replaying, defaults to the feed ttl")
+	return v
+}
+
+func SchedulerConfigFlags(fs *flag.FlagSet) *showrss.SchedulerConfig {
+	v := &showrss.SchedulerConfig{}
+	fs.IntVar(&v.MaxConcurrent, "poll.concurrency", 4, "max number of concurrent feed fetches")
+	fs.DurationVar(&v.HostInterval, "poll.hostinterval", 2*time.Second, "min time between feed fetches to the same host")
+	fs.Float64Var(&v.Jitter, "poll.jitter", 0.1, "random variation of the poll interval as a fraction of the feed ttl")
+	fs.DurationVar(&v.StartSpread, "poll.startspread", 30*time.Second, "spread the first poll of each feed randomly over this duration")
+	return v
+}
+
+func AirScheduleConfigFlags(fs *flag.FlagSet) *showrss.AirScheduleConfig {
+	v := &showrss.AirScheduleConfig{}
+	fs.BoolVar(&v.Enabled, "airschedule", false, "adapt the poll interval of showrss feeds to the air times of their shows from tvmaze")
+	fs.DurationVar(&v.Window, "airschedule.window", 12*time.Hour, "poll actively for this long after an episode air time")
+	fs.DurationVar(&v.ActiveInterval, "airschedule.active", 10*time.Minute, "poll interval after an air time, 0 uses the feed ttl")
+	fs.DurationVar(&v.IdleInterval, "airschedule.idle", 6*time.Hour, "poll interval when no episode has aired recently")
+	fs.DurationVar(&v.CacheTTL, "airschedule.cachettl", 24*time.Hour, "refresh cached show schedules after this long")
+	return v
+}
+
+func RankingConfigFlags(fs *flag.FlagSet) *showrss.RankingConfig {
+	v := &showrss.RankingConfig{
+		Profile: showrss.DefaultRankingProfile,
+	}
+	fs.BoolVar(&v.Enabled, "rank", false, "only add the best ranked release of each episode and replace it when a better release shows up")
+	fs.Var((*stringSliceFlag)(&v.Profile.Resolutions), "rank.resolutions", "release resolutions, best first, comma separated")
+	fs.Var((*stringSliceFlag)(&v.Profile.Sources), "rank.sources", "release sources, best first, comma separated")
+	fs.Var((*stringSliceFlag)(&v.Profile.Codecs), "rank.codecs", "release codecs, best first, comma separated")
+	fs.BoolVar(&v.DeleteData, "rank.deletedata", false, "delete the data of releases replaced by a better release, same as -duplicates.deletedata")
+	return v
+}
+
+func DuplicateConfigFlags(fs *flag.FlagSet) *showrss.DuplicateConfig {
+	v := &showrss.DuplicateConfig{
+		Policy: showrss.DuplicateKeep,
+	}
+	fs.Var((*duplicatePolicyFlag)(&v.Policy), "duplicates", "what to do with another release of an already added episode: keep, skip or replace. Ignored when -rank is enabled")
+	fs.BoolVar(&v.Repacks, "duplicates.repacks", true, "replace releases by a later PROPER or REPACK of the same release group, source and resolution")
+	fs.BoolVar(&v.DeleteData, "duplicates.deletedata", false, "delete the data of replaced releases once the replacement has completed")
+	return v
+}
+
+func NotifyConfigFlags(fs *flag.FlagSet) *showrss.NotifyConfig {
+	v := &showrss.NotifyConfig{}
+	fs.Var(&notifierFlag{notifiers: &v.Notifiers}, "notify",
+		`notifier for the events of all subscriptions: type=url with type webhook, ntfy, gotify or discord, or a json object, e.g. {"type":"gotify","url":"https://gotify.example","token":"...","events":["episode_added"]}. Can be repeated`)
+	fs.DurationVar(&v.Timeout, "notify.timeout", 10*time.Second, "timeout of a single notification")
+	return v
+}
+
+// notifierFlag is a flag type which parses a notifier, repeated flags add
+// notifiers.
+type notifierFlag struct {
+	notifiers *[]showrss.NotifierConfig
+}
+
+func (f *notifierFlag) String() string {
+	if f.notifiers == nil {
+		return ""
+	}
+	var values []string
+	for _, c := range *f.notifiers {
+		values = append(values, c.String())
+	}
+	return strings.Join(values, ",")
+}
 
-func (s *intSliceFlag) String() string {
-	var nums []string
-	for _, n := range *s {
-		nums = append(nums, strconv.Itoa(n))
+func (f *notifierFlag) Set(value string) error {
+	var c showrss.NotifierConfig
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), &c); err != nil {
+			return err
+		}
+	} else {
+		typ, u, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("notifier must be type=url or a json object")
+		}
+		c.Type = showrss.NotifierType(strings.TrimSpace(typ))
+		c.URL = strings.TrimSpace(u)
+	}
+	if _, err := showrss.NewNotifier(c, nil); err != nil {
+		return err
 	}
-	return strings.Join(nums, ",")
+	*f.notifiers = append(*f.notifiers, c)
+	return nil
 }
 
-func (f *intSliceFlag) Set(value string) error {
-	var res intSliceFlag
+func AlertConfigFlags(fs *flag.FlagSet) *showrss.AlertConfig {
+	v := &showrss.AlertConfig{}
+	fs.DurationVar(&v.FeedFailing, "alert.feedfailing", time.Hour, "alert when a feed has been failing for longer than this, 0 disables the alert")
+	fs.DurationVar(&v.FeedSilent, "alert.feedsilent", 0, "alert when a subscription has not delivered a new episode for longer than this, e.g. 336h, 0 disables the alert")
+	fs.DurationVar(&v.TransmissionDown, "alert.transmission", 10*time.Minute, "alert when transmission has been unreachable for longer than this, 0 disables the alert")
+	fs.IntVar(&v.AddFailures, "alert.addfailures", 3, "alert when this many episodes in a row could not be added to transmission, 0 disables the alert")
+	fs.DurationVar(&v.Interval, "alert.interval", time.Minute, "how often the alert rules are checked")
+	return v
+}
+
+func DigestConfigFlags(fs *flag.FlagSet) *showrss.DigestConfig {
+	v := &showrss.DigestConfig{
+		Schedule: showrss.DigestDaily,
+		At:       8 * time.Hour,
+		Weekday:  time.Monday,
+		SMTP: showrss.SMTPConfig{
+			Security: showrss.SMTPStartTLS,
+		},
+	}
+	fs.Var((*stringSliceFlag)(&v.To), "digest.to", "email addresses receiving a digest of added and completed episodes, comma separated. The digest is disabled if empty")
+	fs.Var((*digestScheduleFlag)(&v.Schedule), "digest.schedule", "how often the digest is sent: daily or weekly")
+	fs.Var((*timeOfDayFlag)(&v.At), "digest.at", "local time of day the digest is sent at, HH:MM")
+	fs.Var((*weekdayFlag)(&v.Weekday), "digest.weekday", "day weekly digests are sent on")
+	fs.StringVar(&v.SMTP.Addr, "smtp.addr", "", "smtp server host:port")
+	fs.Var((*smtpSecurityFlag)(&v.SMTP.Security), "smtp.security", "smtp connection security: starttls, tls or none")
+	fs.StringVar(&v.SMTP.User, "smtp.user", "", "smtp username, enables plain auth")
+	fs.StringVar(&v.SMTP.Password, "smtp.pass", "", "smtp password")
+	fs.StringVar(&v.SMTP.From, "smtp.from", "", "sender address of emails")
+	fs.DurationVar(&v.SMTP.Timeout, "smtp.timeout", 30*time.Second, "timeout of sending an email")
+	return v
+}
+
+type digestScheduleFlag showrss.DigestSchedule
+
+func (f *digestScheduleFlag) String() string {
+	return string(*f)
+}
+
+func (f *digestScheduleFlag) Set(value string) error {
+	s, err := showrss.ParseDigestSchedule(value)
+	if err != nil {
+		return err
+	}
+	*f = digestScheduleFlag(s)
+	return nil
+}
+
+type smtpSecurityFlag showrss.SMTPSecurity
+
+func (f *smtpSecurityFlag) String() string {
+	return string(*f)
+}
+
+func (f *smtpSecurityFlag) Set(value string) error {
+	s, err := showrss.ParseSMTPSecurity(value)
+	if err != nil {
+		return err
+	}
+	*f = smtpSecurityFlag(s)
+	return nil
+}
+
+// timeOfDayFlag is a flag type which parses a time of day in the format
+// HH:MM into the duration since midnight.
+type timeOfDayFlag time.Duration
+
+func (f *timeOfDayFlag) String() string {
+	d := time.Duration(*f)
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func (f *timeOfDayFlag) Set(value string) error {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("invalid time of day '%s', must be HH:MM", value)
+	}
+	*f = timeOfDayFlag(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute)
+	return nil
+}
+
+// weekdayFlag is a flag type which parses an english weekday name.
+type weekdayFlag time.Weekday
+
+func (f *weekdayFlag) String() string {
+	return strings.ToLower(time.Weekday(*f).String())
+}
+
+func (f *weekdayFlag) Set(value string) error {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(strings.TrimSpace(value), d.String()) {
+			*f = weekdayFlag(d)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown weekday '%s'", value)
+}
+
+type duplicatePolicyFlag showrss.DuplicatePolicy
+
+func (f *duplicatePolicyFlag) String() string {
+	return string(*f)
+}
+
+func (f *duplicatePolicyFlag) Set(value string) error {
+	p, err := showrss.ParseDuplicatePolicy(value)
+	if err != nil {
+		return err
+	}
+	*f = duplicatePolicyFlag(p)
+	return nil
+}
+
+// stringSliceFlag is a flag type which parses a comma separated list of strings.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	var res stringSliceFlag
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	*s = res
+	return nil
+}
+
+// feedSliceFlag is a flag type which parses a comma separated list of feeds.
+type feedSliceFlag struct {
+	feeds *[]showrss.Feed
+	typ   showrss.FeedType
+}
+
+func (f *feedSliceFlag) String() string {
+	if f.feeds == nil {
+		return ""
+	}
+	var values []string
+	for _, feed := range *f.feeds {
+		s := strconv.Itoa(feed.ID)
+		if feed.Quality != "" {
+			s = fmt.Sprintf("%s:%s", s, feed.Quality)
+		}
+		for _, fb := range feed.Fallbacks {
+			s = fmt.Sprintf("%s>%s@%v", s, fb.Quality, fb.Delay)
+		}
+		values = append(values, s)
+	}
+	return strings.Join(values, ",")
+}
+
+func (f *feedSliceFlag) Set(value string) error {
+	var res []showrss.Feed
 	for _, s := range strings.Split(value, ",") {
-		s = strings.TrimSpace(s)
-		n, err := strconv.Atoi(s)
+		feed, err := parseFeed(f.typ, strings.TrimSpace(s))
 		if err != nil {
 			return err
 		}
-		res = append(res, n)
+		res = append(res, feed)
+	}
+	*f.feeds = res
+	return nil
+}
+
+// parseFeed parses a feed in the format id[:quality[>quality@delay...]].
+func parseFeed(typ showrss.FeedType, s string) (showrss.Feed, error) {
+	feed := showrss.Feed{Type: typ}
+	idStr, chain, hasChain := strings.Cut(s, ":")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return feed, err
+	}
+	feed.ID = id
+	if !hasChain {
+		return feed, nil
+	}
+	qualities := strings.Split(chain, ">")
+	feed.Quality, err = showrss.ParseQuality(qualities[0])
+	if err != nil {
+		return feed, err
+	}
+	for _, v := range qualities[1:] {
+		qStr, delayStr, ok := strings.Cut(v, "@")
+		if !ok {
+			return feed, fmt.Errorf("fallback quality '%s' has no delay", v)
+		}
+		var fb showrss.Fallback
+		if fb.Quality, err = showrss.ParseQuality(qStr); err != nil {
+			return feed, err
+		}
+		if fb.Delay, err = time.ParseDuration(delayStr); err != nil {
+			return feed, err
+		}
+		feed.Fallbacks = append(feed.Fallbacks, fb)
+	}
+	return feed, nil
+}
+
+// urlFeedFlag is a repeatable flag type which parses a generic feed url with
+// an optional show name prefix.
+type urlFeedFlag struct {
+	feeds *[]showrss.Feed
+	typ   showrss.FeedType
+}
+
+func (f *urlFeedFlag) String() string {
+	if f.feeds == nil {
+		return ""
+	}
+	var values []string
+	for _, feed := range *f.feeds {
+		if feed.Type == f.typ {
+			values = append(values, feed.String())
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func (f *urlFeedFlag) Set(value string) error {
+	feed := showrss.Feed{Type: f.typ, URL: strings.TrimSpace(value)}
+	if name, u, ok := strings.Cut(value, "="); ok && !strings.Contains(name, "://") {
+		feed.ShowName = strings.TrimSpace(name)
+		feed.URL = strings.TrimSpace(u)
+	}
+	if _, err := url.ParseRequestURI(feed.URL); err != nil {
+		return err
 	}
-	*f = res
+	*f.feeds = append(*f.feeds, feed)
 	return nil
 }
@@ -0,0 +1,291 @@
+package showrss
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// AirScheduleSource provides the air times of the episodes of a show, by the
+// external (TVmaze) show id.
+type AirScheduleSource interface {
+	AirTimes(ctx context.Context, externalID int) ([]time.Time, error)
+}
+
+// ErrUnknownShow is returned by an AirScheduleSource which has no schedule
+// for a show.
+var ErrUnknownShow = errors.New("unknown show")
+
+const defaultTVMazeURL = "https://api.tvmaze.com"
+
+// TVMaze is an AirScheduleSource using the TVmaze api.
+type TVMaze struct {
+	HTTPClient *http.Client
+	BaseURL    string // defaults to https://api.tvmaze.com
+	UserAgent  string
+}
+
+func (t *TVMaze) AirTimes(ctx context.Context, externalID int) ([]time.Time, error) {
+	baseURL := t.BaseURL
+	if baseURL == "" {
+		baseURL = defaultTVMazeURL
+	}
+	url := fmt.Sprintf("%s/shows/%d/episodes?specials=1", baseURL, externalID)
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := t.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	hc := t.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrUnknownShow
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newHTTPError(url, resp)
+	}
+	data, err := readBody(resp, defaultMaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []struct {
+		Airstamp string `json:"airstamp"`
+	}
+	if err := json.Unmarshal(data, &episodes); err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for _, e := range episodes {
+		if e.Airstamp == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, e.Airstamp)
+		if err != nil {
+			continue
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// AirScheduleConfig configures adaptive polling based on episode air times.
+type AirScheduleConfig struct {
+	Enabled        bool
+	Window         time.Duration // poll actively for this long after an air time
+	ActiveInterval time.Duration // poll interval within the window, zero uses the feed ttl
+	IdleInterval   time.Duration // poll interval outside of the window
+	CacheTTL       time.Duration // refresh cached schedules after this long
+}
+
+// airScheduleRecord is the cached schedule of a show.
+type airScheduleRecord struct {
+	AirTimes []time.Time `json:"air_times"`
+	Unknown  bool        `json:"unknown,omitempty"` // the source has no schedule for the show
+	Fetched  time.Time   `json:"fetched"`
+}
+
+// AirSchedule chooses poll intervals from the air times of the shows in a
+// feed. Schedules are cached in the db and refreshed from the source in the
+// background by Run, so that choosing an interval never waits for the
+// source.
+type AirSchedule struct {
+	config AirScheduleConfig
+	source AirScheduleSource
+	db     *DB
+
+	refreshCh chan int // external ids of shows to refresh
+
+	mu      sync.Mutex
+	pending map[int]bool              // shows waiting for a refresh
+	records map[int]airScheduleRecord // in memory cache, used without db
+}
+
+const airScheduleRefreshBuf = 64
+
+func NewAirSchedule(config AirScheduleConfig, source AirScheduleSource, db *DB) *AirSchedule {
+	return &AirSchedule{
+		config:    config,
+		source:    source,
+		db:        db,
+		refreshCh: make(chan int, airScheduleRefreshBuf),
+		pending:   make(map[int]bool),
+		records:   make(map[int]airScheduleRecord),
+	}
+}
+
+// Run refreshes the schedules requested by Interval until ctx is done.
+func (a *AirSchedule) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case id := <-a.refreshCh:
+			if err := a.refresh(ctx, id, time.Now()); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Int("external_id", id).Msg("could not refresh air schedule")
+			}
+			a.mu.Lock()
+			delete(a.pending, id)
+			a.mu.Unlock()
+		}
+	}
+}
+
+// requestRefresh queues a refresh of the schedule of the show with id.
+func (a *AirSchedule) requestRefresh(id int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending[id] {
+		return
+	}
+	select {
+	case a.refreshCh <- id:
+		a.pending[id] = true
+	default:
+		// requested again by the next poll
+	}
+}
+
+// Interval returns the poll interval of a feed with the shows externalIDs
+// and the feed ttl. Feeds with a show within the window after an air time
+// are polled at the active interval. Otherwise feeds are polled at the idle
+// interval, but not later than the next air time. Feeds with a show without
+// a known schedule are polled at ttl. Only cached schedules are used, shows
+// without a schedule or with a schedule older than the cache ttl are queued
+// for a refresh.
+func (a *AirSchedule) Interval(externalIDs []int, now time.Time, ttl time.Duration) time.Duration {
+	active := ttl
+	if a.config.ActiveInterval > 0 && a.config.ActiveInterval < ttl {
+		active = a.config.ActiveInterval
+	}
+	if len(externalIDs) == 0 {
+		return ttl
+	}
+	var unknown, inWindow bool
+	interval := a.config.IdleInterval
+	for _, id := range externalIDs {
+		rec, found, err := a.getRecord(id)
+		if err != nil {
+			log.Warn().Err(err).Int("external_id", id).Msg("could not get air schedule")
+			unknown = true
+			continue
+		}
+		if !found || now.Sub(rec.Fetched) >= a.config.CacheTTL {
+			a.requestRefresh(id)
+		}
+		if !found || rec.Unknown {
+			unknown = true
+			continue
+		}
+		for _, t := range rec.AirTimes {
+			if !now.Before(t) && now.Before(t.Add(a.config.Window)) {
+				inWindow = true
+			}
+			if t.After(now) && t.Sub(now) < interval {
+				interval = t.Sub(now)
+			}
+		}
+	}
+	switch {
+	case inWindow:
+		return active
+	case unknown:
+		return ttl
+	}
+	if interval < active {
+		interval = active
+	}
+	return interval
+}
+
+// refresh fetches the schedule of the show with id from the source and
+// caches it. The cached schedule is kept if the source fails.
+func (a *AirSchedule) refresh(ctx context.Context, id int, now time.Time) error {
+	times, err := a.source.AirTimes(ctx, id)
+	if err != nil && !errors.Is(err, ErrUnknownShow) {
+		return err
+	}
+	rec := airScheduleRecord{
+		Unknown: err != nil,
+		Fetched: now,
+	}
+	// only keep air times which can still affect polling
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for _, t := range times {
+		if t.Add(a.config.Window).After(now) {
+			rec.AirTimes = append(rec.AirTimes, t)
+		}
+	}
+	return a.putRecord(id, rec)
+}
+
+func (a *AirSchedule) getRecord(id int) (airScheduleRecord, bool, error) {
+	if a.db == nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		rec, ok := a.records[id]
+		return rec, ok, nil
+	}
+	var rec airScheduleRecord
+	var found bool
+	err := a.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketSchedules).Get([]byte(strconv.Itoa(id)))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, found, err
+}
+
+func (a *AirSchedule) putRecord(id int, rec airScheduleRecord) error {
+	if a.db == nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.records[id] = rec
+		return nil
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(&rec)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketSchedules).Put([]byte(strconv.Itoa(id)), data)
+	})
+}
+
+// externalIDs returns the distinct external show ids of the episodes.
+func externalIDs(episodes []Episode) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, e := range episodes {
+		if e.ExternalID != 0 && !seen[e.ExternalID] {
+			seen[e.ExternalID] = true
+			ids = append(ids, e.ExternalID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,117 @@
+package showrss
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAirScheduleSource serves air times from memory and counts the calls.
+type fakeAirScheduleSource struct {
+	mu    sync.Mutex
+	times map[int][]time.Time
+	err   error
+	calls int
+}
+
+func (s *fakeAirScheduleSource) AirTimes(ctx context.Context, externalID int) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	times, ok := s.times[externalID]
+	if !ok {
+		return nil, ErrUnknownShow
+	}
+	return times, nil
+}
+
+func (s *fakeAirScheduleSource) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+var testAirScheduleConfig = AirScheduleConfig{
+	Enabled:        true,
+	Window:         2 * time.Hour,
+	ActiveInterval: 5 * time.Minute,
+	IdleInterval:   6 * time.Hour,
+	CacheTTL:       24 * time.Hour,
+}
+
+func TestAirScheduleInterval(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	const ttl = 30 * time.Minute
+	source := &fakeAirScheduleSource{times: map[int][]time.Time{
+		1: {now.Add(-time.Hour), now.Add(7 * 24 * time.Hour)},      // aired an hour ago
+		2: {now.Add(-48 * time.Hour), now.Add(7 * 24 * time.Hour)}, // next week
+		3: {now.Add(-48 * time.Hour), now.Add(2 * time.Hour)},      // in two hours
+	}}
+	a := NewAirSchedule(testAirScheduleConfig, source, nil)
+	for _, id := range []int{1, 2, 3, 4} {
+		if err := a.refresh(context.Background(), id, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		ids  []int
+		want time.Duration
+	}{
+		{nil, ttl},
+		{[]int{1}, 5 * time.Minute},
+		{[]int{2}, 6 * time.Hour},
+		{[]int{3}, 2 * time.Hour},
+		{[]int{2, 3}, 2 * time.Hour},
+		{[]int{4}, ttl}, // unknown show
+		{[]int{1, 4}, 5 * time.Minute},
+		{[]int{5}, ttl}, // not cached yet
+	}
+	for _, tt := range tests {
+		if got := a.Interval(tt.ids, now, ttl); got != tt.want {
+			t.Errorf("interval of %v: %v, want %v", tt.ids, got, tt.want)
+		}
+	}
+	if calls := source.callCount(); calls != 4 {
+		t.Errorf("source called %d times by Interval, want only the 4 refreshes", calls)
+	}
+}
+
+func TestAirScheduleRefresh(t *testing.T) {
+	now := time.Now()
+	source := &fakeAirScheduleSource{times: map[int][]time.Time{
+		1: {now.Add(-time.Hour)},
+	}}
+	a := NewAirSchedule(testAirScheduleConfig, source, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx)
+
+	const ttl = 30 * time.Minute
+	if got := a.Interval([]int{1}, now, ttl); got != ttl {
+		t.Fatalf("interval before refresh %v, want %v", got, ttl)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Interval([]int{1}, now, ttl) != 5*time.Minute {
+		if time.Now().After(deadline) {
+			t.Fatal("schedule was not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a failing source keeps the stale schedule
+	source.mu.Lock()
+	source.err = errors.New("connection refused")
+	source.mu.Unlock()
+	later := now.Add(testAirScheduleConfig.CacheTTL)
+	if err := a.refresh(ctx, 1, later); err == nil {
+		t.Fatal("refresh did not fail")
+	}
+	if got := a.Interval([]int{1}, now, ttl); got != 5*time.Minute {
+		t.Fatalf("interval with stale schedule %v, want %v", got, 5*time.Minute)
+	}
+}
@@ -0,0 +1,378 @@
+package showrss
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Alert rules.
+const (
+	AlertFeedFailing             = "feed_failing"
+	AlertFeedSilent              = "feed_silent"
+	AlertTransmissionUnreachable = "transmission_unreachable"
+	AlertAddFailures             = "add_failures"
+)
+
+// AlertConfig configures the alert rules. A zero threshold disables its
+// rule.
+type AlertConfig struct {
+	FeedFailing      time.Duration // a feed source has been failing for longer than this
+	FeedSilent       time.Duration // a subscription has not delivered a new episode for longer than this
+	TransmissionDown time.Duration // transmission has been unreachable for longer than this
+	AddFailures      int           // this many episodes in a row could not be added
+	Interval         time.Duration // how often the rules are checked
+}
+
+func (c AlertConfig) Enabled() bool {
+	return c.FeedFailing > 0 || c.FeedSilent > 0 || c.TransmissionDown > 0 || c.AddFailures > 0
+}
+
+const defaultAlertInterval = time.Minute
+
+// alertCondition is a broken alert rule.
+type alertCondition struct {
+	Key          string `json:"key"` // rule and subject, unique per condition
+	Rule         string `json:"rule"`
+	Subscription string `json:"subscription,omitempty"`
+	Description  string `json:"description"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (c alertCondition) event(typ EventType) Event {
+	return Event{
+		Type:         typ,
+		Alert:        c.Rule,
+		Subscription: c.Subscription,
+		Reason:       c.Description,
+		Error:        c.Error,
+	}
+}
+
+// alerter tracks the firing alerts and publishes an alert firing event when
+// a condition starts and an alert resolved event when it clears. The firing
+// alerts are stored in the db, so that a restart neither fires them again
+// nor misses their recovery.
+type alerter struct {
+	events *EventHub
+	db     *DB
+
+	mu     sync.Mutex
+	firing map[string]alertCondition // by key
+
+	addFailures int // episodes in a row which could not be added
+}
+
+var (
+	keyAlertsFiring  = []byte("alerts_firing")
+	keyAlertsStarted = []byte("alerts_started")
+)
+
+func newAlerter(events *EventHub, db *DB) *alerter {
+	a := &alerter{events: events, db: db, firing: make(map[string]alertCondition)}
+	if db == nil {
+		return a
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketMeta).Get(keyAlertsFiring)
+		if data == nil {
+			return nil
+		}
+		var firing []alertCondition
+		if err := json.Unmarshal(data, &firing); err != nil {
+			return err
+		}
+		for _, c := range firing {
+			a.firing[c.Key] = c
+			metricAlertsFiring.add(1, c.Rule)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("could not restore firing alerts")
+	}
+	return a
+}
+
+// save stores the firing alerts.
+func (a *alerter) save() {
+	if a.db == nil {
+		return
+	}
+	firing := make([]alertCondition, 0, len(a.firing))
+	for _, c := range a.firing {
+		firing = append(firing, c)
+	}
+	sort.Slice(firing, func(i, j int) bool { return firing[i].Key < firing[j].Key })
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(firing)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMeta).Put(keyAlertsFiring, data)
+	})
+	if err != nil {
+		log.Err(err).Msg("could not store firing alerts")
+	}
+}
+
+// isFiring reports whether the condition with key is firing.
+func (a *alerter) isFiring(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.firing[key]
+	return ok
+}
+
+// resolveDisabled clears the firing conditions of the rules which are
+// disabled in config, they were restored from a run with other rules.
+func (a *alerter) resolveDisabled(config AlertConfig) {
+	enabled := map[string]bool{
+		AlertFeedFailing:             config.FeedFailing > 0,
+		AlertFeedSilent:              config.FeedSilent > 0,
+		AlertTransmissionUnreachable: config.TransmissionDown > 0,
+		AlertAddFailures:             config.AddFailures > 0,
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range a.firing {
+		if !enabled[c.Rule] {
+			a.setLocked(c, false)
+		}
+	}
+}
+
+// set starts or clears the condition c.
+func (a *alerter) set(c alertCondition, active bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setLocked(c, active)
+}
+
+func (a *alerter) setLocked(c alertCondition, active bool) {
+	fired, firing := a.firing[c.Key]
+	switch {
+	case active && !firing:
+		a.firing[c.Key] = c
+		a.save()
+		metricAlertsFiring.add(1, c.Rule)
+		log.Warn().Str("alert", c.Rule).Str("subscription", c.Subscription).Str("error", c.Error).Msg(c.Description)
+		a.events.Publish(c.event(EventAlertFiring))
+	case !active && firing:
+		delete(a.firing, c.Key)
+		a.save()
+		metricAlertsFiring.add(-1, c.Rule)
+		log.Info().Str("alert", c.Rule).Str("subscription", c.Subscription).Msg("resolved: " + fired.Description)
+		e := fired.event(EventAlertResolved)
+		e.Error = ""
+		a.events.Publish(e)
+	}
+}
+
+// sync makes the active conditions the firing conditions of rule, clearing
+// the conditions which are no longer active.
+func (a *alerter) sync(rule string, active []alertCondition) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := make(map[string]bool, len(active))
+	for _, c := range active {
+		keys[c.Key] = true
+		a.setLocked(c, true)
+	}
+	for key, c := range a.firing {
+		if c.Rule == rule && !keys[key] {
+			a.setLocked(c, false)
+		}
+	}
+}
+
+// watchAlerts periodically checks the alert rules.
+func (d *ShowRSSDownloader) watchAlerts(ctx context.Context) error {
+	interval := d.Alerts.Interval
+	if interval <= 0 {
+		interval = defaultAlertInterval
+	}
+	start, err := d.DB.alertsStarted()
+	if err != nil {
+		return err
+	}
+	var transmissionDownSince time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if d.Alerts.TransmissionDown > 0 {
+			c := alertCondition{
+				Key:         AlertTransmissionUnreachable,
+				Rule:        AlertTransmissionUnreachable,
+				Description: "transmission is unreachable",
+			}
+			err := d.checkTransmission(ctx)
+			if err != nil {
+				if transmissionDownSince.IsZero() {
+					transmissionDownSince = now
+				}
+				c.Error = err.Error()
+			} else {
+				transmissionDownSince = time.Time{}
+			}
+			// a firing alert stays firing until transmission is reachable,
+			// also when it was restored after a restart
+			down := !transmissionDownSince.IsZero() &&
+				(now.Sub(transmissionDownSince) >= d.Alerts.TransmissionDown || d.alerts.isFiring(c.Key))
+			d.alerts.set(c, down)
+		}
+		if d.Alerts.FeedFailing > 0 {
+			d.alerts.sync(AlertFeedFailing, d.failingFeeds(now))
+		}
+		if d.Alerts.FeedSilent > 0 {
+			silent, err := d.silentSubscriptions(now, start)
+			if err != nil {
+				log.Err(err).Msg("error checking for silent subscriptions")
+			} else {
+				d.alerts.sync(AlertFeedSilent, silent)
+			}
+		}
+	}
+}
+
+// failingFeeds returns the feed sources which have been failing for longer
+// than the feed failing threshold. Firing feeds stay firing until they are
+// fetched successfully, also while they have not been fetched since a
+// restart.
+func (d *ShowRSSDownloader) failingFeeds(now time.Time) []alertCondition {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var active []alertCondition
+	for id, rm := range d.monitors {
+		for _, h := range rm.health {
+			st := h.Status()
+			key := AlertFeedFailing + "/" + st.Source
+			firing := d.alerts.isFiring(key)
+			switch {
+			case st.State == FeedStatePending && firing:
+			case st.FailingSince.IsZero():
+				continue
+			case now.Sub(st.FailingSince) < d.Alerts.FeedFailing && !firing:
+				continue
+			}
+			c := alertCondition{
+				Key:          key,
+				Rule:         AlertFeedFailing,
+				Subscription: id,
+				Description:  fmt.Sprintf("feed %s has been failing since %s", st.Source, st.FailingSince.Format(time.RFC3339)),
+				Error:        st.LastError,
+			}
+			if st.State == FeedStatePending {
+				c.Description = fmt.Sprintf("feed %s was failing before the restart", st.Source)
+			}
+			active = append(active, c)
+		}
+	}
+	return active
+}
+
+// silentSubscriptions returns the active subscriptions which have not
+// delivered a new episode for longer than the feed silent threshold.
+// Subscriptions without known episodes are silent since start at the
+// earliest, the time the alerts were first watched.
+func (d *ShowRSSDownloader) silentSubscriptions(now, start time.Time) ([]alertCondition, error) {
+	subs, err := d.DB.listSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]time.Time)
+	err = d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAdded).ForEach(func(k, v []byte) error {
+			var dbep dbEpisode
+			if err := json.Unmarshal(v, &dbep); err != nil {
+				return nil
+			}
+			if dbep.Subscription != "" && dbep.Created.After(latest[dbep.Subscription]) {
+				latest[dbep.Subscription] = dbep.Created
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	var active []alertCondition
+	for _, sub := range subs {
+		if sub.Paused {
+			continue
+		}
+		last, ok := latest[sub.ID]
+		if !ok {
+			// items added before they recorded their subscription are
+			// not counted, wait from the start
+			last = start
+		}
+		if sub.Created.After(last) {
+			last = sub.Created
+		}
+		if now.Sub(last) < d.Alerts.FeedSilent {
+			continue
+		}
+		active = append(active, alertCondition{
+			Key:          AlertFeedSilent + "/" + sub.ID,
+			Rule:         AlertFeedSilent,
+			Subscription: sub.ID,
+			Description:  fmt.Sprintf("subscription %s has not delivered a new episode since %s", sub.ID, last.Format(time.RFC3339)),
+		})
+	}
+	return active, nil
+}
+
+// countAddFailures is the event handler firing the add failures alert after
+// too many episodes in a row could not be added, and clearing it when an
+// episode is added again.
+func (d *ShowRSSDownloader) countAddFailures(e Event) {
+	a := d.alerts
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := alertCondition{
+		Key:  AlertAddFailures,
+		Rule: AlertAddFailures,
+	}
+	switch e.Type {
+	case EventFailed:
+		a.addFailures++
+		c.Description = fmt.Sprintf("%d episodes in a row could not be added to transmission", a.addFailures)
+		c.Error = e.Error
+		a.setLocked(c, a.addFailures >= d.Alerts.AddFailures)
+	case EventAdded:
+		a.addFailures = 0
+		a.setLocked(c, false)
+	}
+}
+
+// alertsStarted returns the time alerts were first watched, storing the
+// current time on the first call.
+func (db *DB) alertsStarted() (time.Time, error) {
+	var t time.Time
+	err := db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if data := meta.Get(keyAlertsStarted); data != nil {
+			return t.UnmarshalText(data)
+		}
+		t = time.Now()
+		data, err := t.MarshalText()
+		if err != nil {
+			return err
+		}
+		return meta.Put(keyAlertsStarted, data)
+	})
+	return t, err
+}
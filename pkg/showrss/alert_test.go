@@ -0,0 +1,72 @@
+package showrss
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlerterRestoresFiringAlerts(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := alertCondition{Key: AlertTransmissionUnreachable, Rule: AlertTransmissionUnreachable, Description: "transmission is unreachable"}
+	silent := alertCondition{Key: AlertFeedSilent + "/a", Rule: AlertFeedSilent, Subscription: "a"}
+
+	events := NewEventHub()
+	ch, cancel := events.Subscribe("test")
+	defer cancel()
+	a := newAlerter(events, db)
+	a.set(c, true)
+	a.set(silent, true)
+	for i := 0; i < 2; i++ {
+		if e := <-ch; e.Type != EventAlertFiring {
+			t.Fatalf("event %s, want %s", e.Type, EventAlertFiring)
+		}
+	}
+
+	// restart
+	a = newAlerter(events, db)
+	if !a.isFiring(c.Key) || !a.isFiring(silent.Key) {
+		t.Fatal("firing alerts not restored")
+	}
+	a.set(c, true)
+	a.resolveDisabled(AlertConfig{TransmissionDown: time.Minute})
+	if e := <-ch; e.Type != EventAlertResolved || e.Alert != AlertFeedSilent {
+		t.Fatalf("event %s %s, want resolved %s", e.Type, e.Alert, AlertFeedSilent)
+	}
+	a.set(c, false)
+	if e := <-ch; e.Type != EventAlertResolved || e.Alert != AlertTransmissionUnreachable {
+		t.Fatalf("event %s %s, want resolved %s", e.Type, e.Alert, AlertTransmissionUnreachable)
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event %s %s", e.Type, e.Alert)
+	default:
+	}
+
+	if a = newAlerter(events, db); len(a.firing) != 0 {
+		t.Fatalf("resolved alerts restored: %v", a.firing)
+	}
+}
+
+func TestAlertsStarted(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	first, err := db.alertsStarted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := db.alertsStarted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equal(first) {
+		t.Fatalf("alerts started %v, then %v", first, again)
+	}
+}
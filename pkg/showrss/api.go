@@ -0,0 +1,318 @@
+package showrss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiV1Prefix = "/api/v1/"
+
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// apiV1Handler serves the versioned REST api:
+//
+//	GET    /api/v1/episodes                 list the episode history
+//	GET    /api/v1/episodes/{hash}          get a history item
+//	DELETE /api/v1/episodes/{hash}          remove a history item so that it is added again
+//	GET    /api/v1/subscriptions            list subscriptions
+//	POST   /api/v1/subscriptions            add a subscription from a Feed json body
+//	GET    /api/v1/subscriptions/{id}       get a subscription
+//	PATCH  /api/v1/subscriptions/{id}       pause or resume a subscription or replace its notifiers:
+//	                                        {"paused": bool, "notifiers": [NotifierConfig]}
+//	DELETE /api/v1/subscriptions/{id}       remove a subscription
+//	POST   /api/v1/subscriptions/{id}/poll  poll a subscription now
+//	GET    /api/v1/feeds                    list the state of the feed sources
+//	GET    /api/v1/events                   stream downloader events as server-sent events
+//
+// Subscription ids contain slashes and must be path escaped.
+func apiV1Handler(d *ShowRSSDownloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts, err := pathParts(r, apiV1Prefix)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		switch {
+		case len(parts) == 1 && parts[0] == "episodes":
+			episodesV1Handler(w, r, d)
+		case len(parts) == 2 && parts[0] == "episodes":
+			episodeV1Handler(w, r, d, parts[1])
+		case len(parts) == 1 && parts[0] == "subscriptions":
+			subscriptionsV1Handler(w, r, d)
+		case len(parts) == 2 && parts[0] == "subscriptions":
+			subscriptionV1Handler(w, r, d, parts[1])
+		case len(parts) == 3 && parts[0] == "subscriptions" && parts[2] == "poll":
+			subscriptionPollV1Handler(w, r, d, parts[1])
+		case len(parts) == 1 && parts[0] == "feeds":
+			feedsHandler(d)(w, r)
+		case len(parts) == 1 && parts[0] == "events":
+			eventsHandler(d)(w, r)
+		default:
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+		}
+	})
+}
+
+// pathParts returns the unescaped path segments of r after prefix.
+func pathParts(r *http.Request, prefix string) ([]string, error) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	if p == "" {
+		return nil, nil
+	}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		s, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = s
+	}
+	return parts, nil
+}
+
+// episodeItem is an item of the episode history.
+type episodeItem struct {
+	dbEpisode
+	Torrent *TorrentState `json:"torrent,omitempty"`
+}
+
+// episodePage is a page of the episode history.
+type episodePage struct {
+	Items         []episodeItem `json:"items"`
+	Total         int           `json:"total"`
+	Offset        int           `json:"offset"`
+	Limit         int           `json:"limit"`
+	TorrentsError string        `json:"torrents_error,omitempty"`
+}
+
+// episodesV1Handler lists the episode history, newest first. Query
+// parameters: show, since, until (RFC 3339 or YYYY-MM-DD), state (added,
+// skipped, replaced), offset and limit. With torrents=true the transmission
+// state of each item is included.
+func episodesV1Handler(w http.ResponseWriter, r *http.Request, d *ShowRSSDownloader) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	filter, err := parseEpisodeFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	items, total, err := d.DB.listEpisodes(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	page := episodePage{
+		Items:  make([]episodeItem, len(items)),
+		Total:  total,
+		Offset: filter.Offset,
+		Limit:  filter.Limit,
+	}
+	for i, item := range items {
+		page.Items[i].dbEpisode = item
+	}
+	if withTorrents, _ := strconv.ParseBool(r.URL.Query().Get("torrents")); withTorrents && len(items) > 0 {
+		hashes := make([]string, len(items))
+		for i, item := range items {
+			hashes[i] = item.Episode.InfoHash
+		}
+		states, err := d.torrentStates(r.Context(), hashes)
+		if err != nil {
+			page.TorrentsError = err.Error()
+		}
+		for i := range page.Items {
+			if state, ok := states[strings.ToLower(hashes[i])]; ok {
+				page.Items[i].Torrent = &state
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func parseEpisodeFilter(q url.Values) (EpisodeFilter, error) {
+	f := EpisodeFilter{
+		Show:  q.Get("show"),
+		State: EpisodeState(q.Get("state")),
+		Limit: defaultPageLimit,
+	}
+	switch f.State {
+	case "", EpisodeStateAdded, EpisodeStateSkipped, EpisodeStateReplaced:
+	default:
+		return f, fmt.Errorf("unknown state '%s'", f.State)
+	}
+	var err error
+	if f.Since, err = parseQueryTime(q.Get("since")); err != nil {
+		return f, fmt.Errorf("invalid since: %v", err)
+	}
+	if f.Until, err = parseQueryTime(q.Get("until")); err != nil {
+		return f, fmt.Errorf("invalid until: %v", err)
+	}
+	if s := q.Get("offset"); s != "" {
+		if f.Offset, err = strconv.Atoi(s); err != nil || f.Offset < 0 {
+			return f, fmt.Errorf("invalid offset '%s'", s)
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 1 || f.Limit > maxPageLimit {
+			return f, fmt.Errorf("invalid limit '%s', must be between 1 and %d", s, maxPageLimit)
+		}
+	}
+	return f, nil
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// episodeV1Handler gets or deletes the history item with infoHash.
+func episodeV1Handler(w http.ResponseWriter, r *http.Request, d *ShowRSSDownloader, infoHash string) {
+	switch r.Method {
+	case http.MethodGet:
+		dbep, err := d.DB.getEpisode(infoHash)
+		if err != nil {
+			writeError(w, episodeErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, dbep)
+	case http.MethodDelete:
+		if err := d.DeleteEpisode(infoHash); err != nil {
+			writeError(w, episodeErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+func episodeErrorStatus(err error) int {
+	if errors.Is(err, ErrEpisodeNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// subscriptionPatch is the body of a subscription PATCH request. Notifiers
+// replace all notifiers of the subscription, responses do not include their
+// secrets so they must be given again.
+type subscriptionPatch struct {
+	Paused    *bool             `json:"paused"`
+	Notifiers *[]NotifierConfig `json:"notifiers"`
+}
+
+// subscriptionsV1Handler lists subscriptions on GET and adds a subscription
+// from a Feed json body on POST.
+func subscriptionsV1Handler(w http.ResponseWriter, r *http.Request, d *ShowRSSDownloader) {
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := d.Subscriptions()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		redacted := make([]Subscription, len(subs))
+		for i, sub := range subs {
+			redacted[i] = sub.redacted()
+		}
+		writeJSON(w, http.StatusOK, redacted)
+	case http.MethodPost:
+		var feed Feed
+		if err := json.NewDecoder(r.Body).Decode(&feed); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sub, err := d.AddSubscription(feed)
+		if err != nil {
+			writeError(w, subscriptionErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, sub.redacted())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+// subscriptionV1Handler gets, updates or removes the subscription with id.
+func subscriptionV1Handler(w http.ResponseWriter, r *http.Request, d *ShowRSSDownloader, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		sub, err := d.DB.getSubscription(id)
+		if err != nil {
+			writeError(w, subscriptionErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sub.redacted())
+	case http.MethodPatch:
+		var patch subscriptionPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		patchSubscription(w, d, id, patch)
+	case http.MethodDelete:
+		if err := d.RemoveSubscription(id); err != nil {
+			writeError(w, subscriptionErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+// patchSubscription applies patch to the subscription with id and responds
+// with the updated subscription.
+func patchSubscription(w http.ResponseWriter, d *ShowRSSDownloader, id string, patch subscriptionPatch) {
+	if patch.Paused == nil && patch.Notifiers == nil {
+		writeError(w, http.StatusBadRequest, errors.New("nothing to update"))
+		return
+	}
+	var sub Subscription
+	var err error
+	if patch.Notifiers != nil {
+		if sub, err = d.SetSubscriptionNotifiers(id, *patch.Notifiers); err != nil {
+			writeError(w, subscriptionErrorStatus(err), err)
+			return
+		}
+	}
+	if patch.Paused != nil {
+		if sub, err = d.SetSubscriptionPaused(id, *patch.Paused); err != nil {
+			writeError(w, subscriptionErrorStatus(err), err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, sub.redacted())
+}
+
+// subscriptionPollV1Handler polls the subscription with id as soon as
+// possible.
+func subscriptionPollV1Handler(w http.ResponseWriter, r *http.Request, d *ShowRSSDownloader, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	if err := d.PollNow(id); err != nil {
+		writeError(w, subscriptionErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
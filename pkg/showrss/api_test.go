@@ -0,0 +1,103 @@
+package showrss
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// doRequest sends a request to srv without following redirects.
+func doRequest(t *testing.T, srv *httptest.Server, method, path, body string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAPIV1GenericSubscriptionID(t *testing.T) {
+	db := newTestDB(t)
+	d := &ShowRSSDownloader{DB: db}
+	sub := newSubscription(Feed{Type: FeedTypeRSS, URL: "https://example.com/feed.xml"})
+	if err := db.putSubscription(sub); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newAPIHandler(d, nil))
+	defer srv.Close()
+	path := "/api/v1/subscriptions/" + url.PathEscape(sub.ID)
+
+	resp := doRequest(t, srv, http.MethodGet, path, "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var got Subscription
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != sub.ID {
+		t.Fatalf("GET: got subscription %s, want %s", got.ID, sub.ID)
+	}
+
+	// the downloader is not started, so the subscription can not be polled
+	if resp := doRequest(t, srv, http.MethodPost, path+"/poll", "", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("POST poll: status %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if resp := doRequest(t, srv, http.MethodPatch, path, `{"paused": true}`, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if sub, err := db.getSubscription(sub.ID); err != nil || !sub.Paused {
+		t.Fatalf("PATCH: paused %v, err %v", sub.Paused, err)
+	}
+	if resp := doRequest(t, srv, http.MethodDelete, path, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp := doRequest(t, srv, http.MethodGet, path, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET after DELETE: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAPIV1Routes(t *testing.T) {
+	db := newTestDB(t)
+	d := &ShowRSSDownloader{DB: db}
+	srv := httptest.NewServer(newAPIHandler(d, nil))
+	defer srv.Close()
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/api/v1/episodes", http.StatusOK},
+		{http.MethodGet, "/api/v1/episodes?limit=0", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/episodes/0123456789abcdef", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/episodes", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/subscriptions", http.StatusOK},
+		{http.MethodGet, "/api/v1/subscriptions/show%2F1", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/subscriptions/show%2F1/poll", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/feeds", http.StatusOK},
+		{http.MethodGet, "/api/v1/unknown", http.StatusNotFound},
+		{http.MethodGet, "/subscriptions", http.StatusOK},
+		{http.MethodGet, "/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if resp := doRequest(t, srv, tt.method, tt.path, "", nil); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
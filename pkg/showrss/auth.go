@@ -0,0 +1,186 @@
+package showrss
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+// APIConfig configures the api listener.
+type APIConfig struct {
+	Addr    string
+	TLSCert string // tls certificate file, the listener uses plain http if empty
+	TLSKey  string // tls private key file
+
+	ReadTokens  []string // bearer tokens with read access
+	AdminTokens []string // bearer tokens with read and write access
+	ReadUsers   []string // basic auth user:password with read access
+	AdminUsers  []string // basic auth user:password with read and write access
+}
+
+// Scope is the access level of an api credential.
+type Scope int
+
+const (
+	ScopeNone  Scope = iota
+	ScopeRead        // GET and HEAD requests
+	ScopeAdmin       // all requests
+)
+
+// requiredScope returns the scope needed for r.
+func requiredScope(r *http.Request) Scope {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return ScopeRead
+	}
+	return ScopeAdmin
+}
+
+type apiCredential struct {
+	secret string // token or user:password
+	scope  Scope
+}
+
+// authenticator checks the credentials of api requests. A nil
+// *authenticator does not require credentials.
+type authenticator struct {
+	tokens []apiCredential
+	users  []apiCredential
+}
+
+const authRealm = "transmission-showrss"
+
+// newAuthenticator returns the authenticator for c, or nil if no credentials
+// are configured.
+func newAuthenticator(c APIConfig) (*authenticator, error) {
+	a := &authenticator{}
+	add := func(list *[]apiCredential, secrets []string, scope Scope, basic bool) error {
+		for _, s := range secrets {
+			if s == "" {
+				continue
+			}
+			if basic && !strings.Contains(s, ":") {
+				return fmt.Errorf("basic auth credentials must be user:password")
+			}
+			*list = append(*list, apiCredential{secret: s, scope: scope})
+		}
+		return nil
+	}
+	if err := add(&a.tokens, c.ReadTokens, ScopeRead, false); err != nil {
+		return nil, err
+	}
+	if err := add(&a.tokens, c.AdminTokens, ScopeAdmin, false); err != nil {
+		return nil, err
+	}
+	if err := add(&a.users, c.ReadUsers, ScopeRead, true); err != nil {
+		return nil, err
+	}
+	if err := add(&a.users, c.AdminUsers, ScopeAdmin, true); err != nil {
+		return nil, err
+	}
+	if len(a.tokens) == 0 && len(a.users) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// match returns the highest scope of the credentials matching secret. All
+// credentials are compared so that the time taken does not depend on which
+// one matches.
+func match(creds []apiCredential, secret string) Scope {
+	scope := ScopeNone
+	for _, c := range creds {
+		if subtle.ConstantTimeCompare([]byte(c.secret), []byte(secret)) == 1 && c.scope > scope {
+			scope = c.scope
+		}
+	}
+	return scope
+}
+
+// scope returns the scope of the credentials of r.
+func (a *authenticator) scope(r *http.Request) Scope {
+	if user, pass, ok := r.BasicAuth(); ok {
+		return match(a.users, user+":"+pass)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return match(a.tokens, strings.TrimSpace(auth[len("Bearer "):]))
+	}
+	// browser EventSource clients can not set headers. Tokens in the query
+	// string end up in proxy and access logs, prefer the Authorization
+	// header where possible.
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Accept") == "text/event-stream" {
+		return match(a.tokens, token)
+	}
+	return ScopeNone
+}
+
+var (
+	errUnauthorized       = errors.New("unauthorized")
+	errForbidden          = errors.New("forbidden")
+	errCrossSite          = errors.New("cross-site request")
+	errUnsupportedContent = errors.New("content type must be application/json")
+)
+
+// checkCrossSite rejects write requests sent by a browser from another site.
+// Browsers attach cached basic auth credentials to cross-site requests, so
+// those are only accepted from the same origin. Requests with a body must be
+// json, which a cross-site form can not send.
+func checkCrossSite(r *http.Request) (int, error) {
+	if requiredScope(r) < ScopeAdmin {
+		return 0, nil
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		if site != "same-origin" && site != "none" {
+			return http.StatusForbidden, errCrossSite
+		}
+	} else if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return http.StatusForbidden, errCrossSite
+		}
+	}
+	if r.ContentLength != 0 {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return http.StatusUnsupportedMediaType, errUnsupportedContent
+		}
+	}
+	return 0, nil
+}
+
+// handler requires credentials with the scope needed by each request before
+// passing it to next. Write requests from other sites are rejected, also
+// when no credentials are configured.
+func (a *authenticator) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a != nil {
+			scope := a.scope(r)
+			if scope == ScopeNone {
+				if len(a.users) > 0 {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+				} else {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", authRealm))
+				}
+				writeError(w, http.StatusUnauthorized, errUnauthorized)
+				return
+			}
+			if scope < requiredScope(r) {
+				log.Warn().Str("method", r.Method).Str("path", r.URL.Path).Msg("api request denied, admin scope required")
+				writeError(w, http.StatusForbidden, errForbidden)
+				return
+			}
+		}
+		if status, err := checkCrossSite(r); err != nil {
+			log.Warn().Str("method", r.Method).Str("path", r.URL.Path).Str("origin", r.Header.Get("Origin")).Msg("api request denied: " + err.Error())
+			writeError(w, status, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
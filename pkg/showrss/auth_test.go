@@ -0,0 +1,92 @@
+package showrss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticatorScopes(t *testing.T) {
+	a, err := newAuthenticator(APIConfig{
+		ReadTokens:  []string{"read"},
+		AdminTokens: []string{"admin"},
+		ReadUsers:   []string{"reader:secret"},
+		AdminUsers:  []string{"root:secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		method string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{http.MethodGet, func(r *http.Request) {}, http.StatusUnauthorized},
+		{http.MethodGet, func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{http.MethodGet, func(r *http.Request) { r.Header.Set("Authorization", "Bearer read") }, http.StatusNoContent},
+		{http.MethodPost, func(r *http.Request) { r.Header.Set("Authorization", "Bearer read") }, http.StatusForbidden},
+		{http.MethodPost, func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin") }, http.StatusNoContent},
+		{http.MethodGet, func(r *http.Request) { r.SetBasicAuth("reader", "wrong") }, http.StatusUnauthorized},
+		{http.MethodHead, func(r *http.Request) { r.SetBasicAuth("reader", "secret") }, http.StatusNoContent},
+		{http.MethodDelete, func(r *http.Request) { r.SetBasicAuth("reader", "secret") }, http.StatusForbidden},
+		{http.MethodDelete, func(r *http.Request) { r.SetBasicAuth("root", "secret") }, http.StatusNoContent},
+		// tokens in the query string are only accepted from event streams
+		{http.MethodGet, func(r *http.Request) { r.URL.RawQuery = "access_token=read" }, http.StatusUnauthorized},
+		{http.MethodGet, func(r *http.Request) {
+			r.URL.RawQuery = "access_token=read"
+			r.Header.Set("Accept", "text/event-stream")
+		}, http.StatusNoContent},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/api/v1/subscriptions", nil)
+		tt.auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%d: %s: status %d, want %d", i, tt.method, w.Code, tt.status)
+		}
+	}
+}
+
+func TestAuthenticatorCrossSite(t *testing.T) {
+	a, err := newAuthenticator(APIConfig{AdminUsers: []string{"root:secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		method, body string
+		header       map[string]string
+		status       int
+	}{
+		{http.MethodPost, `{}`, map[string]string{"Content-Type": "application/json"}, http.StatusNoContent},
+		{http.MethodPost, `{}`, map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusNoContent},
+		{http.MethodPost, `{}`, map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{http.MethodPost, `{}`, nil, http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", nil, http.StatusNoContent},
+		{http.MethodPost, "", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{http.MethodPost, "", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{http.MethodPost, "", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusNoContent},
+		{http.MethodDelete, "", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{http.MethodDelete, "", map[string]string{"Origin": "http://example.com"}, http.StatusNoContent},
+		{http.MethodGet, "", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusNoContent},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com/api/v1/subscriptions", strings.NewReader(tt.body))
+		r.SetBasicAuth("root", "secret")
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%d: %s %v: status %d, want %d", i, tt.method, tt.header, w.Code, tt.status)
+		}
+	}
+}
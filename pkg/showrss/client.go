@@ -2,29 +2,94 @@ package showrss
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/cenkalti/backoff"
 	"github.com/some-programs/transmission-showrss/pkg/log"
 )
 
 type clientOpt func(c *Client) error
 
-func ClientTTL(ttl time.Duration) clientOpt {
+// ClientDB makes the client store http cache validators per feed url in db and
+// use them for conditional requests.
+func ClientDB(db *DB) clientOpt {
 	return func(c *Client) error {
-		c.ttl = &ttl
+		c.db = db
+		return nil
+	}
+}
+
+// ClientHTTPClient sets the http client used to fetch feeds.
+func ClientHTTPClient(hc *http.Client) clientOpt {
+	return func(c *Client) error {
+		c.httpClient = hc
+		return nil
+	}
+}
+
+// ClientUserAgent sets the User-Agent header of feed requests.
+func ClientUserAgent(ua string) clientOpt {
+	return func(c *Client) error {
+		c.userAgent = ua
+		return nil
+	}
+}
+
+// ClientTimeout sets the timeout of a single feed request.
+func ClientTimeout(timeout time.Duration) clientOpt {
+	return func(c *Client) error {
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// ClientMaxBodySize limits the size of feed responses.
+func ClientMaxBodySize(size int64) clientOpt {
+	return func(c *Client) error {
+		c.maxBodySize = size
+		return nil
+	}
+}
+
+// ClientRanking makes the client order the episodes of each fetched feed by
+// release rank, best first.
+func ClientRanking(profile RankingProfile) clientOpt {
+	return func(c *Client) error {
+		c.ranking = &profile
 		return nil
 	}
 }
 
 func NewClient(opts ...clientOpt) *Client {
-	c := &Client{}
+	c := &Client{
+		httpClient:  http.DefaultClient,
+		userAgent:   defaultUserAgent,
+		timeout:     defaultTimeout,
+		maxBodySize: defaultMaxBodySize,
+		channels:    make(map[string]*Channel),
+	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.replayDir != "" {
+		c.httpClient = &http.Client{Transport: &replayTransport{dir: c.replayDir}}
+	} else if c.recordDir != "" {
+		hc := *c.httpClient
+		next := hc.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		hc.Transport = &recordTransport{
+			next:      next,
+			dir:       c.recordDir,
+			retention: c.recordRetention,
+			maxSize:   c.maxBodySize,
+		}
+		c.httpClient = &hc
+	}
 	return c
 }
 
@@ -32,10 +97,28 @@ const defaultBaseURL = "http://showrss.info"
 
 // Client .
 type Client struct {
-	ttl     *time.Duration
 	baseURL string
+	db      *DB
+
+	httpClient  *http.Client
+	userAgent   string
+	timeout     time.Duration
+	maxBodySize int64
+
+	ranking *RankingProfile
+
+	recordDir       string
+	recordRetention time.Duration
+	replayDir       string
+
+	mu       sync.Mutex
+	channels map[string]*Channel // last handled channel per url, re-delivered on 304
 }
 
+// ErrNotModified is returned by conditional fetches when the server reports
+// that the feed has not changed but there is no handled channel to return.
+var ErrNotModified = errors.New("feed not modified")
+
 func (c *Client) makeURL(path string) string {
 	baseURL := c.baseURL
 	if baseURL == "" {
@@ -44,111 +127,130 @@ func (c *Client) makeURL(path string) string {
 	return fmt.Sprintf("%v%v", baseURL, path)
 }
 
-func (c *Client) GetUserFeed(ctx context.Context, ID int) (*Channel, error) {
-	return c.get(ctx, c.makeURL(fmt.Sprintf("/user/%v.rss?magnets=true&namespaces=true&name=clean&quality=null&re=yes", ID)))
+func (c *Client) userFeedURL(ID int, quality Quality) string {
+	return c.makeURL(fmt.Sprintf("/user/%v.rss?magnets=true&namespaces=true&name=clean&quality=%v&re=yes", ID, quality.param()))
+}
+
+func (c *Client) showFeedURL(ID int, quality Quality) string {
+	return c.makeURL(fmt.Sprintf("/show/%v.rss?magnets=true&namespaces=true&name=clean&quality=%v&re=yes", ID, quality.param()))
+}
+
+func (c *Client) GetUserFeed(ctx context.Context, ID int, quality Quality) (*Channel, error) {
+	return c.get(ctx, c.userFeedURL(ID, quality))
 }
 
-func (c *Client) GetShowFeed(ctx context.Context, ID int) (*Channel, error) {
-	return c.get(ctx, c.makeURL(fmt.Sprintf("/show/%v.rss?magnets=true&namespaces=true&name=clean&quality=fhd&re=yes", ID)))
+func (c *Client) GetShowFeed(ctx context.Context, ID int, quality Quality) (*Channel, error) {
+	return c.get(ctx, c.showFeedURL(ID, quality))
 }
 
 func (c *Client) get(ctx context.Context, url string) (*Channel, error) {
-	log.Info().Str("feed_url", url).Msg("")
-	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	return c.fetch(ctx, url, ParseRSS, false)
+}
+
+// parseFunc parses a feed response body.
+type parseFunc func(data []byte) (*Channel, error)
+
+// fetch fetches url and parses the response with parse. If conditional is
+// true and a channel of url has been handled before, also before a restart,
+// its cache validators are sent and the handled channel is returned with
+// NotModified set if the server reports that the feed is unchanged.
+func (c *Client) fetch(ctx context.Context, url string, parse parseFunc, conditional bool) (*Channel, error) {
+	log.Info().Str("feed_url", redactURL(url)).Msg("")
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept-Encoding", "gzip")
+	var last *Channel
+	if conditional {
+		last = c.lastChannel(url)
+		if last != nil {
+			if last.validators.ETag != "" {
+				req.Header.Set("If-None-Match", last.validators.ETag)
+			}
+			if last.validators.LastModified != "" {
+				req.Header.Set("If-Modified-Since", last.validators.LastModified)
+			}
+		}
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	data, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		if last == nil {
+			return nil, ErrNotModified
+		}
+		channel := *last
+		channel.Episodes = append([]Episode(nil), last.Episodes...)
+		channel.NotModified = true
+		return &channel, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newHTTPError(url, resp)
+	}
+	data, err := readBody(resp, c.maxBodySize)
 	if err != nil {
 		return nil, err
 	}
-	channel, err := ParseRSS(data)
+	channel, err := parse(data)
 	if err != nil {
 		return nil, err
 	}
 	channel.URL = url
+	channel.validators = feedValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if c.ranking != nil {
+		c.ranking.sortEpisodes(channel.Episodes)
+	}
 	return channel, nil
 }
 
-func (c *Client) MonitorChannel(ctx context.Context, channel Channel, episodeCh chan<- Episode) error {
-	var ttl time.Duration
-	if c.ttl != nil {
-		ttl = *c.ttl
-	} else {
-		ttl = channel.TTLDuration()
-	}
-	log.Debug().Msgf("tt: %v", ttl)
-
-	var currentChannel *Channel
-
-	var last time.Time
-	var failures int
-	var bo *backoff.ExponentialBackOff
-	currentChannel = &channel
-loop:
-	for {
-		if currentChannel == nil {
-			log.Debug().Msgf("get channel.URL: %v", channel.URL)
-			var err error
-			currentChannel, err = c.get(context.Background(), channel.URL)
-			if err != nil {
-				if bo == nil {
-					bo = &backoff.ExponentialBackOff{
-						InitialInterval:     2 * time.Second,
-						RandomizationFactor: 0.5,
-						Multiplier:          1.5,
-						MaxInterval:         time.Hour,
-						MaxElapsedTime:      0, // never stop
-						Clock:               backoff.SystemClock,
-					}
-					bo.Reset()
-				}
-				failures++
-				next := bo.NextBackOff()
-				log.Warn().Msgf("failures: %v next:%v err: %v", failures, next, err)
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(next):
-					continue loop
-				}
-			}
-		}
-		bo = nil
-		failures = 0
-		last = time.Now()
-		if currentChannel != nil {
-			epslen := len(currentChannel.Episodes)
-			log.Debug().Msgf("episodes: %v", epslen)
-			if currentChannel.Episodes != nil {
-				for _, item := range currentChannel.Episodes {
-					item := item
-					logger := getLogger(item)
-					select {
-					case <-ctx.Done():
-						return ctx.Err()
-					case episodeCh <- item:
-						logger.Debug().Interface("item", item).Msg("sent")
-					}
-				}
-			}
-			currentChannel = nil
-		}
-		waitFor := ttl - time.Since(last)
-		log.Info().Msgf("will wait for %v", waitFor)
-		if waitFor > 0 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(waitFor):
-				continue loop
-			}
-		}
+// lastChannel returns the last handled channel of url, loading it from the db
+// after a restart. It returns nil if there is no channel to re-deliver.
+func (c *Client) lastChannel(url string) *Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.channels[url]; ok || c.db == nil {
+		return last
+	}
+	v, err := c.db.getFeedValidators(url)
+	if err != nil {
+		log.Warn().Err(err).Str("feed_url", redactURL(url)).Msg("could not read feed validators")
+		return nil
+	}
+	last := v.Channel
+	if last != nil {
+		last.URL = url
+		last.validators = feedValidators{ETag: v.ETag, LastModified: v.LastModified}
+	}
+	c.channels[url] = last
+	return last
+}
+
+// handled keeps channel for re-delivery and stores it with its cache
+// validators. It is called after all episodes of the channel were delivered
+// so that a feed is not reported unchanged before its episodes were handled.
+func (c *Client) handled(channel *Channel) {
+	if channel.NotModified {
+		return
+	}
+	c.mu.Lock()
+	c.channels[channel.URL] = channel
+	c.mu.Unlock()
+	if c.db == nil {
+		return
+	}
+	v := channel.validators
+	v.Updated = time.Now()
+	v.Channel = channel
+	if err := c.db.putFeedValidators(channel.URL, v); err != nil {
+		log.Warn().Err(err).Str("feed_url", redactURL(channel.URL)).Msg("could not store feed validators")
 	}
 }
@@ -0,0 +1,114 @@
+package showrss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:tv="http://showrss.info">
+<channel>
+<title>showRSS: feed</title>
+<ttl>30</ttl>
+<item>
+<title>Show S01E02 720p</title>
+<tv:info_hash>0123456789ABCDEF0123456789ABCDEF01234567</tv:info_hash>
+</item>
+</channel>
+</rss>`
+
+func TestFetchNotModifiedRedeliversHandledChannel(t *testing.T) {
+	var conditional []bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match") != "")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := NewClient(ClientDB(db))
+	ctx := context.Background()
+
+	ch, err := c.fetch(ctx, srv.URL, ParseRSS, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.NotModified || len(ch.Episodes) != 1 {
+		t.Fatalf("first fetch: not modified %v, %d episodes", ch.NotModified, len(ch.Episodes))
+	}
+
+	// validators are not used until the channel was handled
+	if _, err := c.fetch(ctx, srv.URL, ParseRSS, true); err != nil {
+		t.Fatal(err)
+	}
+	c.handled(ch)
+
+	ch, err = c.fetch(ctx, srv.URL, ParseRSS, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ch.NotModified || len(ch.Episodes) != 1 {
+		t.Fatalf("conditional fetch: not modified %v, %d episodes", ch.NotModified, len(ch.Episodes))
+	}
+	if want := "[false false true]"; fmt.Sprint(conditional) != want {
+		t.Fatalf("conditional requests %v, want %v", conditional, want)
+	}
+}
+
+func TestFetchNotModifiedAfterRestart(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	ch, err := NewClient(ClientDB(db)).fetch(ctx, srv.URL, ParseRSS, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewClient(ClientDB(db)).handled(ch)
+
+	// a fresh client backed by the same db
+	ch, err = NewClient(ClientDB(db)).fetch(ctx, srv.URL, ParseRSS, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ch.NotModified {
+		t.Fatal("fetch after restart was not conditional")
+	}
+	if len(ch.Episodes) != 1 || ch.Episodes[0].InfoHash != "0123456789abcdef0123456789abcdef01234567" || ch.TTL != 30 || ch.URL != srv.URL {
+		t.Fatalf("re-delivered channel %+v", ch)
+	}
+	if ch.Episodes[0].Number.Season != 1 || fmt.Sprint(ch.Episodes[0].Number.Episodes) != "[2]" || ch.Episodes[0].Release.Resolution != "720p" {
+		t.Fatalf("re-delivered episode %+v", ch.Episodes[0])
+	}
+	if requests != 2 {
+		t.Fatalf("%d requests, want 2", requests)
+	}
+}
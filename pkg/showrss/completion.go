@@ -0,0 +1,106 @@
+package showrss
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	completionInterval = 2 * time.Minute
+	completionMaxAge   = 30 * 24 * time.Hour // stop watching items added longer ago
+)
+
+var keyCompletionsTracked = []byte("completions_tracked")
+
+// watchCompletions periodically records when added torrents have finished
+// downloading and publishes an episode completed event for each. The first
+// check after upgrading records completions without events so that already
+// finished torrents are not reported.
+func (d *ShowRSSDownloader) watchCompletions(ctx context.Context) error {
+	ticker := time.NewTicker(completionInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.checkCompletions(ctx); err != nil {
+			log.Err(err).Msg("error checking torrent completions")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *ShowRSSDownloader) checkCompletions(ctx context.Context) error {
+	var hashes []string
+	var tracked bool
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		tracked = tx.Bucket(bucketMeta).Get(keyCompletionsTracked) != nil
+		since := time.Now().Add(-completionMaxAge)
+		return tx.Bucket(bucketAdded).ForEach(func(k, v []byte) error {
+			var dbep dbEpisode
+			if err := json.Unmarshal(v, &dbep); err != nil {
+				return nil
+			}
+			if dbep.state() == EpisodeStateAdded && dbep.Completed.IsZero() && dbep.Created.After(since) {
+				hashes = append(hashes, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	states, err := d.torrentStates(ctx, hashes)
+	if err != nil {
+		return err
+	}
+	var completed []dbEpisode
+	now := time.Now()
+	err = d.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAdded)
+		for _, h := range hashes {
+			state, ok := states[h]
+			if !ok || state.Status == torrentStatusMissing || state.DataDone < 1 {
+				continue
+			}
+			data := bucket.Get([]byte(h))
+			if data == nil {
+				continue
+			}
+			var dbep dbEpisode
+			if err := json.Unmarshal(data, &dbep); err != nil {
+				return err
+			}
+			dbep.Completed = now
+			data, err := json.Marshal(&dbep)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(h), data); err != nil {
+				return err
+			}
+			completed = append(completed, dbep)
+		}
+		return tx.Bucket(bucketMeta).Put(keyCompletionsTracked, []byte("1"))
+	})
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		log.Info().Int("completed", len(completed)).Msg("recorded completions of previously added torrents")
+		return nil
+	}
+	for _, dbep := range completed {
+		logger := getLogger(dbep.Episode)
+		logger.Info().Msg("torrent completed")
+		e := episodeEvent(EventCompleted, dbep.Subscription, dbep.Episode)
+		e.DownloadDir = d.episodeDownloadDir(dbep.Episode)
+		d.Events().Publish(e)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package showrss
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed web
+var webFS embed.FS
+
+const dashboardPrefix = "/ui/"
+
+// dashboardHandler serves the web dashboard. It uses the versioned api.
+func dashboardHandler() http.Handler {
+	sub, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(dashboardPrefix, http.FileServer(http.FS(sub)))
+}
+
+// wantsHTML reports whether r comes from a browser.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
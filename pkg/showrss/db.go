@@ -1,6 +1,7 @@
 package showrss
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -8,13 +9,25 @@ import (
 )
 
 var (
-	bucketAdded = []byte("added")
+	bucketAdded           = []byte("added")
+	bucketSubscriptions   = []byte("subscriptions")
+	bucketFallback        = []byte("fallback")
+	bucketFeeds           = []byte("feeds")
+	bucketMeta            = []byte("meta")
+	bucketEpisodes        = []byte("episodes")
+	bucketPendingRemovals = []byte("pending_removals")
+	bucketSchedules       = []byte("schedules")
 	// bucketTorrents = []byte("torrents")
-	// bucketFeeds    = []byte("feeds")
 	allBuckets = [][]byte{
 		bucketAdded,
+		bucketSubscriptions,
+		bucketFallback,
+		bucketFeeds,
+		bucketMeta,
+		bucketEpisodes,
+		bucketPendingRemovals,
+		bucketSchedules,
 		// bucketTorrents,
-		// bucketFeeds,
 	}
 )
 
@@ -42,13 +55,39 @@ func NewDB(filename string) (*DB, error) {
 			return nil, err
 		}
 	}
+	if err := db.migrateSubscriptions(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate subscriptions: %v", err)
+	}
+	if err := db.migrateEpisodeIndex(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate episode index: %v", err)
+	}
 	return db, nil
 }
 
+// EpisodeState is the state of an item in the episode history.
+type EpisodeState string
+
+const (
+	EpisodeStateAdded    EpisodeState = "added"    // added to transmission
+	EpisodeStateSkipped  EpisodeState = "skipped"  // rejected by the duplicate handling
+	EpisodeStateReplaced EpisodeState = "replaced" // replaced by another release
+)
+
 type dbEpisode struct {
-	Created time.Time `json:"created"`
-	Updated time.Time `json:"updated"`
-	Episode Episode   `json:"episode"`
+	Created time.Time    `json:"created"`
+	Updated time.Time    `json:"updated"`
+	Episode Episode      `json:"episode"`
+	State   EpisodeState `json:"state,omitempty"`
+
+	Subscription string `json:"subscription,omitempty"` // id of the subscription which delivered the episode
+
+	Completed time.Time `json:"completed,omitempty"` // when transmission finished downloading the torrent
+
+	Replaces      string `json:"replaces,omitempty"`       // info hash of the release this release replaced
+	ReplacedBy    string `json:"replaced_by,omitempty"`    // info hash of the release which replaced this release
+	ReplaceReason string `json:"replace_reason,omitempty"` // why the replacement happened
 }
 
 func newDBEpisode(e Episode) (dbEpisode, error) {
@@ -59,3 +98,35 @@ func newDBEpisode(e Episode) (dbEpisode, error) {
 		Episode: e,
 	}, nil
 }
+
+// feedValidators are the http cache validators of the last handled fetch of a
+// feed url, stored with the handled channel which is re-delivered when the
+// server reports that the feed has not changed.
+type feedValidators struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Updated      time.Time `json:"updated"`
+	Channel      *Channel  `json:"channel,omitempty"`
+}
+
+func (db *DB) getFeedValidators(url string) (feedValidators, error) {
+	var v feedValidators
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketFeeds).Get([]byte(url))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &v)
+	})
+	return v, err
+}
+
+func (db *DB) putFeedValidators(url string, v feedValidators) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(&v)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketFeeds).Put([]byte(url), data)
+	})
+}
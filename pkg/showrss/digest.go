@@ -0,0 +1,357 @@
+package showrss
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DigestSchedule is how often the email digest is sent.
+type DigestSchedule string
+
+const (
+	DigestDaily  DigestSchedule = "daily"
+	DigestWeekly DigestSchedule = "weekly"
+)
+
+func ParseDigestSchedule(s string) (DigestSchedule, error) {
+	switch v := DigestSchedule(strings.ToLower(strings.TrimSpace(s))); v {
+	case DigestDaily, DigestWeekly:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown digest schedule '%s', must be daily or weekly", s)
+}
+
+// SMTPSecurity is how the connection to the smtp server is secured.
+type SMTPSecurity string
+
+const (
+	SMTPStartTLS SMTPSecurity = "starttls" // upgrade a plain connection, fail if the server does not support it
+	SMTPTLS      SMTPSecurity = "tls"      // implicit tls, usually on port 465
+	SMTPNone     SMTPSecurity = "none"
+)
+
+func ParseSMTPSecurity(s string) (SMTPSecurity, error) {
+	switch v := SMTPSecurity(strings.ToLower(strings.TrimSpace(s))); v {
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown smtp security '%s', must be starttls, tls or none", s)
+}
+
+// SMTPConfig configures the smtp server emails are sent through.
+type SMTPConfig struct {
+	Addr     string // host:port
+	Security SMTPSecurity
+	User     string // plain auth is used if set
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+// DigestConfig configures the email digest of added and completed episodes.
+// The digest is disabled if To is empty.
+type DigestConfig struct {
+	SMTP     SMTPConfig
+	To       []string
+	Schedule DigestSchedule
+	At       time.Duration // time of day the digest is sent at, local time
+	Weekday  time.Weekday  // day weekly digests are sent on
+}
+
+func (c DigestConfig) Enabled() bool {
+	return len(c.To) > 0
+}
+
+// Validate checks that the configuration can be used to send digests.
+func (c DigestConfig) Validate() error {
+	switch {
+	case c.SMTP.Addr == "":
+		return errors.New("digest: smtp address is required")
+	case c.SMTP.From == "":
+		return errors.New("digest: smtp from address is required")
+	case c.At < 0 || c.At >= 24*time.Hour:
+		return fmt.Errorf("digest: invalid time of day %v", c.At)
+	}
+	return nil
+}
+
+// next returns the first time a digest is due after t.
+func (c DigestConfig) next(t time.Time) time.Time {
+	hour, min := int(c.At/time.Hour), int(c.At%time.Hour/time.Minute)
+	y, m, day := t.Date()
+	next := time.Date(y, m, day, hour, min, 0, 0, t.Location())
+	for !next.After(t) || (c.Schedule == DigestWeekly && next.Weekday() != c.Weekday) {
+		day++
+		next = time.Date(y, m, day, hour, min, 0, 0, t.Location())
+	}
+	return next
+}
+
+var keyDigestSent = []byte("digest_sent")
+
+const digestRetryInterval = 15 * time.Minute
+
+// sendDigests sends the email digest on schedule. The time of the last
+// digest is stored so that a restart neither resends nor skips a digest. A
+// digest without episodes is not sent.
+func (d *ShowRSSDownloader) sendDigests(ctx context.Context) error {
+	last, err := d.DB.digestSent()
+	if err != nil {
+		return err
+	}
+	if last.IsZero() {
+		// first start, do not send the whole history
+		last = time.Now()
+		if err := d.DB.setDigestSent(last); err != nil {
+			return err
+		}
+	}
+	for {
+		due := d.Digest.next(last)
+		log.Debug().Time("due", due).Msg("next email digest")
+		for {
+			if err := sleepCtx(ctx, time.Until(due)); err != nil {
+				return err
+			}
+			now := time.Now()
+			if err := d.sendDigest(ctx, last, now); err != nil {
+				log.Err(err).Msg("could not send email digest")
+				due = now.Add(digestRetryInterval)
+				continue
+			}
+			if err := d.DB.setDigestSent(now); err != nil {
+				return err
+			}
+			last = now
+			break
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// sendDigest sends the digest of the episodes added or completed between
+// since and until.
+func (d *ShowRSSDownloader) sendDigest(ctx context.Context, since, until time.Time) error {
+	digest, err := d.DB.digest(since, until)
+	if err != nil {
+		return err
+	}
+	if digest.empty() {
+		log.Info().Msg("no new episodes, skipping email digest")
+		return nil
+	}
+	msg := digest.message(d.Digest.SMTP.From, d.Digest.To, until)
+	if err := sendMail(ctx, d.Digest.SMTP, d.Digest.To, msg); err != nil {
+		return err
+	}
+	log.Info().Int("shows", len(digest.Shows)).Strs("to", d.Digest.To).Msg("sent email digest")
+	return nil
+}
+
+func (db *DB) digestSent() (time.Time, error) {
+	var t time.Time
+	err := db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(bucketMeta).Get(keyDigestSent); data != nil {
+			return t.UnmarshalText(data)
+		}
+		return nil
+	})
+	return t, err
+}
+
+func (db *DB) setDigestSent(t time.Time) error {
+	data, err := t.MarshalText()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(keyDigestSent, data)
+	})
+}
+
+// digestShow are the episodes of a show in a digest.
+type digestShow struct {
+	Name      string
+	Added     []dbEpisode
+	Completed []dbEpisode
+}
+
+// episodeDigest lists the episodes added and completed in a period, grouped
+// by show.
+type episodeDigest struct {
+	Since, Until time.Time
+	Shows        []digestShow // ordered by name
+}
+
+func (e episodeDigest) empty() bool {
+	return len(e.Shows) == 0
+}
+
+// digest returns the episodes added to transmission or completed between
+// since and until.
+func (db *DB) digest(since, until time.Time) (episodeDigest, error) {
+	in := func(t time.Time) bool {
+		return !t.Before(since) && t.Before(until)
+	}
+	shows := make(map[string]*digestShow)
+	show := func(name string) *digestShow {
+		if name == "" {
+			name = "Unknown show"
+		}
+		s, ok := shows[name]
+		if !ok {
+			s = &digestShow{Name: name}
+			shows[name] = s
+		}
+		return s
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAdded).ForEach(func(k, v []byte) error {
+			var dbep dbEpisode
+			if err := json.Unmarshal(v, &dbep); err != nil {
+				return nil
+			}
+			if dbep.state() == EpisodeStateSkipped {
+				return nil
+			}
+			if in(dbep.Created) {
+				s := show(dbep.Episode.ShowName)
+				s.Added = append(s.Added, dbep)
+			}
+			if in(dbep.Completed) {
+				s := show(dbep.Episode.ShowName)
+				s.Completed = append(s.Completed, dbep)
+			}
+			return nil
+		})
+	})
+	digest := episodeDigest{Since: since, Until: until}
+	for _, s := range shows {
+		sort.Slice(s.Added, func(i, j int) bool { return s.Added[i].Created.Before(s.Added[j].Created) })
+		sort.Slice(s.Completed, func(i, j int) bool { return s.Completed[i].Completed.Before(s.Completed[j].Completed) })
+		digest.Shows = append(digest.Shows, *s)
+	}
+	sort.Slice(digest.Shows, func(i, j int) bool {
+		return strings.ToLower(digest.Shows[i].Name) < strings.ToLower(digest.Shows[j].Name)
+	})
+	return digest, err
+}
+
+// message returns the digest as a plain text email.
+func (e episodeDigest) message(from string, to []string, date time.Time) []byte {
+	var added, completed int
+	for _, s := range e.Shows {
+		added += len(s.Added)
+		completed += len(s.Completed)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: transmission-showrss: %d added, %d completed\r\n", added, completed)
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+
+	const layout = "2006-01-02 15:04"
+	fmt.Fprintf(&buf, "Episodes from %s to %s\r\n", e.Since.Format(layout), e.Until.Format(layout))
+	for _, s := range e.Shows {
+		fmt.Fprintf(&buf, "\r\n%s\r\n", s.Name)
+		for _, dbep := range s.Added {
+			fmt.Fprintf(&buf, "  added      %s  %s\r\n", dbep.Created.Format(layout), dbep.Episode.Title)
+		}
+		for _, dbep := range s.Completed {
+			fmt.Fprintf(&buf, "  completed  %s  %s\r\n", dbep.Completed.Format(layout), dbep.Episode.Title)
+		}
+	}
+	return buf.Bytes()
+}
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// sendMail sends msg to the recipients through the smtp server of c.
+func sendMail(ctx context.Context, c SMTPConfig, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address: %v", err)
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	tlsConfig := &tls.Config{ServerName: host}
+	var conn net.Conn
+	if c.Security == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.Addr, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if c.Security == SMTPStartTLS || c.Security == "" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if c.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.User, c.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,111 @@
+package showrss
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDigestConfigNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	daily := DigestConfig{Schedule: DigestDaily, At: 8*time.Hour + 30*time.Minute}
+	weekly := DigestConfig{Schedule: DigestWeekly, At: 8 * time.Hour, Weekday: time.Monday}
+	tests := []struct {
+		config DigestConfig
+		t      string
+		want   string
+	}{
+		{daily, "2024-01-03 07:00", "2024-01-03 08:30"},
+		{daily, "2024-01-03 08:30", "2024-01-04 08:30"},
+		{daily, "2024-01-03 23:59", "2024-01-04 08:30"},
+		{daily, "2024-01-31 09:00", "2024-02-01 08:30"},
+		{daily, "2024-12-31 09:00", "2025-01-01 08:30"},
+		// 2024-01-01 is a monday
+		{weekly, "2024-01-01 07:00", "2024-01-01 08:00"},
+		{weekly, "2024-01-01 08:00", "2024-01-08 08:00"},
+		{weekly, "2024-01-03 12:00", "2024-01-08 08:00"},
+		{weekly, "2024-01-27 12:00", "2024-01-29 08:00"},
+	}
+	for _, tt := range tests {
+		if got := tt.config.next(at(tt.t)); !got.Equal(at(tt.want)) {
+			t.Errorf("%s %s: next %s, want %s", tt.config.Schedule, tt.t, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestDBDigest(t *testing.T) {
+	db := newTestDB(t)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := since.Add(24 * time.Hour)
+	items := []dbEpisode{
+		{Created: since.Add(time.Hour), Episode: Episode{InfoHash: "a", Title: "B Show S01E01", ShowName: "b show"}},
+		{Created: since.Add(2 * time.Hour), Completed: since.Add(3 * time.Hour), Episode: Episode{InfoHash: "b", Title: "A Show S01E02", ShowName: "A Show"}},
+		{Created: since.Add(-time.Hour), Completed: since.Add(4 * time.Hour), Episode: Episode{InfoHash: "c", Title: "A Show S01E01", ShowName: "A Show"}},
+		{Created: since.Add(time.Hour), State: EpisodeStateSkipped, Episode: Episode{InfoHash: "d", Title: "A Show S01E02 skipped", ShowName: "A Show"}},
+		{Created: until, Episode: Episode{InfoHash: "e", Title: "A Show S01E03", ShowName: "A Show"}},
+		{Created: since.Add(-time.Hour), Episode: Episode{InfoHash: "f", Title: "C Show S01E01", ShowName: "C Show"}},
+		{Created: since.Add(5 * time.Hour), Episode: Episode{InfoHash: "g", Title: "No name S01E01"}},
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, item := range items {
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketAdded).Put([]byte(item.Episode.InfoHash), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := db.digest(since, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range digest.Shows {
+		for _, dbep := range s.Added {
+			got = append(got, s.Name+": added "+dbep.Episode.InfoHash)
+		}
+		for _, dbep := range s.Completed {
+			got = append(got, s.Name+": completed "+dbep.Episode.InfoHash)
+		}
+	}
+	want := []string{
+		"A Show: added b",
+		"A Show: completed b",
+		"A Show: completed c",
+		"b show: added a",
+		"Unknown show: added g",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("digest:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	msg := string(digest.message("from@example.com", []string{"to@example.com"}, until))
+	if !strings.Contains(msg, "Subject: transmission-showrss: 3 added, 2 completed\r\n") {
+		t.Errorf("message subject:\n%s", msg)
+	}
+
+	empty, err := db.digest(until.Add(time.Hour), until.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !empty.empty() {
+		t.Errorf("digest without episodes has %d shows", len(empty.Shows))
+	}
+}
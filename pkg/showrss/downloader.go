@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -22,12 +24,22 @@ func getLogger(item Episode) zerolog.Logger {
 }
 
 type FeedSelection struct {
-	Shows []int
-	Users []int
+	Shows    []Feed
+	Users    []Feed
+	URLFeeds []Feed // generic rss, atom and torznab feeds
+}
+
+// Feeds returns all selected feeds.
+func (f FeedSelection) Feeds() []Feed {
+	var feeds []Feed
+	feeds = append(feeds, f.Users...)
+	feeds = append(feeds, f.Shows...)
+	feeds = append(feeds, f.URLFeeds...)
+	return feeds
 }
 
 func (f FeedSelection) IsEmtpy() bool {
-	return len(f.Users) == 0 && len(f.Shows) == 0
+	return len(f.Users) == 0 && len(f.Shows) == 0 && len(f.URLFeeds) == 0
 }
 
 type ShowDirs struct {
@@ -36,16 +48,36 @@ type ShowDirs struct {
 }
 
 type ShowRSSDownloader struct {
-	TC        *transmission.Client
-	Selection FeedSelection
-	ShowDirs  ShowDirs
-	DB        *DB
+	TC          *transmission.Client
+	Selection   FeedSelection
+	ShowDirs    ShowDirs
+	HTTP        HTTPConfig
+	Record      RecordConfig
+	Scheduler   SchedulerConfig
+	AirSchedule AirScheduleConfig
+	Ranking     RankingConfig
+	Duplicates  DuplicateConfig
+	Notify      NotifyConfig
+	Digest      DigestConfig
+	Alerts      AlertConfig
+	DB          *DB
 
 	sessionDownloadDir string
 
 	started   bool
-	newItemCh chan Episode // items coming from rss subscriptions
+	newItemCh chan queuedEpisode // items coming from rss subscriptions
+
+	client    *Client
+	scheduler *Scheduler
+	mu        sync.Mutex
+	monitors  map[string]*runningMonitor // running monitors by subscription id
+	events    *EventHub
+	alerts    *alerter
 
+	notifyClient    *http.Client
+	notifiers       []configuredNotifier            // global notifiers
+	subNotifiers    map[string][]configuredNotifier // notifiers of subscriptions by id, built on first use
+	subNotifiersGen int                             // incremented when subNotifiers entries are reset
 }
 
 func (d *ShowRSSDownloader) Start(ctx context.Context) error {
@@ -53,7 +85,7 @@ func (d *ShowRSSDownloader) Start(ctx context.Context) error {
 		return errors.New("already started")
 	}
 	d.started = true
-	d.newItemCh = make(chan Episode)
+	d.newItemCh = make(chan queuedEpisode)
 
 	session, err := d.TC.GetSession(context.Background(), transmission.SessionFieldDownloadDirectory)
 	if err != nil {
@@ -61,45 +93,86 @@ func (d *ShowRSSDownloader) Start(ctx context.Context) error {
 	}
 	d.sessionDownloadDir = session.DownloadDirectory
 
-	show := NewClient()
-
-	eg, ctx := errgroup.WithContext(ctx)
-	for _, userID := range d.Selection.Users {
-		channel, err := show.GetUserFeed(ctx, userID)
+	clientOpts, err := d.HTTP.ClientOpts()
+	if err != nil {
+		return err
+	}
+	clientOpts = append(clientOpts, d.Record.ClientOpts()...)
+	if d.Ranking.Enabled {
+		clientOpts = append(clientOpts, ClientRanking(d.Ranking.Profile))
+	}
+	d.client = NewClient(append(clientOpts, ClientDB(d.DB))...)
+	schedulerOpts := append(d.Scheduler.SchedulerOpts(), d.Record.SchedulerOpts()...)
+	schedulerOpts = append(schedulerOpts, SchedulerEvents(d.Events()))
+	if d.AirSchedule.Enabled {
+		hc, err := d.HTTP.NewClient()
 		if err != nil {
-			return fmt.Errorf("error during initial fetch of user channel %v: %v", userID, err)
+			return err
 		}
-		log.Info().
-			Int("user_id", userID).
-			Str("title", channel.Title).
-			Msg("adding monitor for user")
-
-		monitorFunc := func(channel Channel) func() error {
-			return func() error {
-				return show.MonitorChannel(ctx, channel, d.newItemCh)
-			}
+		source := &TVMaze{HTTPClient: hc, UserAgent: d.HTTP.UserAgent}
+		schedulerOpts = append(schedulerOpts, SchedulerAirSchedule(NewAirSchedule(d.AirSchedule, source, d.DB)))
+	}
+	d.scheduler = NewScheduler(schedulerOpts...)
+
+	if d.Digest.Enabled() {
+		if err := d.Digest.Validate(); err != nil {
+			return err
 		}
-		eg.Go(monitorFunc(*channel))
 	}
 
-	for _, showID := range d.Selection.Shows {
-		channel, err := show.GetShowFeed(ctx, showID)
-		if err != nil {
-			return fmt.Errorf("error during initial fetch of show channel %v: %v", showID, err)
+	hc, err := d.HTTP.NewClient()
+	if err != nil {
+		return err
+	}
+	d.notifyClient = withUserAgent(hc, d.HTTP.UserAgent)
+	if d.notifiers, err = newNotifiers(d.Notify.Notifiers, d.notifyClient); err != nil {
+		return err
+	}
+
+	seeded, err := d.DB.seedSubscriptions(d.Selection.Feeds())
+	if err != nil {
+		return fmt.Errorf("error seeding subscriptions: %v", err)
+	}
+	if !seeded && !d.Selection.IsEmtpy() {
+		log.Info().Msg("subscriptions already seeded, ignoring feed selection flags")
+	}
+	subs, err := d.DB.listSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.monitors = make(map[string]*runningMonitor)
+	d.mu.Unlock()
+	for _, sub := range subs {
+		if sub.Paused {
+			log.Info().Str("subscription", sub.ID).Msg("subscription is paused")
+			continue
 		}
-		log.Info().
-			Int("show_id", showID).
-			Str("title", channel.Title).
-			Msg("adding monitor for show")
-		monitorFunc := func(channel Channel) func() error {
-			return func() error {
-				return show.MonitorChannel(ctx, channel, d.newItemCh)
-			}
+		if err := d.startMonitor(sub); err != nil {
+			return err
 		}
-		eg.Go(monitorFunc(*channel))
 	}
 
+	defer d.Events().Handle("metrics", countEpisodeEvent, episodeEventTypes...)()
+	defer d.Events().Handle("notify", d.notify, append(episodeEventTypes, alertEventTypes...)...)()
+	d.alerts = newAlerter(d.Events(), d.DB)
+	d.alerts.resolveDisabled(d.Alerts)
+	if d.Alerts.AddFailures > 0 {
+		defer d.Events().Handle("alerts", d.countAddFailures, EventAdded, EventFailed)()
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error { return d.scheduler.Run(ctx) })
 	eg.Go(func() error { return d.handleItems(ctx) })
+	eg.Go(func() error { return d.processPendingRemovals(ctx) })
+	eg.Go(func() error { return d.watchCompletions(ctx) })
+	if d.Digest.Enabled() {
+		eg.Go(func() error { return d.sendDigests(ctx) })
+	}
+	if d.Alerts.Enabled() {
+		eg.Go(func() error { return d.watchAlerts(ctx) })
+	}
 
 	if err := eg.Wait(); err != nil {
 		return err
@@ -107,18 +180,79 @@ func (d *ShowRSSDownloader) Start(ctx context.Context) error {
 	return nil
 }
 
+// queuedEpisode is an episode waiting to be handled.
+type queuedEpisode struct {
+	Episode
+	subscription string // subscription id
+	known        bool   // the episode was in the history when it was delivered
+}
+
+// deliverFunc returns the function receiving the episodes of the source at
+// position index in the quality chain of sub. It passes on the items
+// accepted by the quality fallback rules. Feeds deliver all their items on
+// every poll, lifecycle events are only published for items which are not
+// in the history yet.
+func (d *ShowRSSDownloader) deliverFunc(sub Subscription, index int) DeliverFunc {
+	feed := sub.Feed
+	return func(ctx context.Context, item Episode) error {
+		logger := getLogger(item)
+		known, err := d.DB.hasEpisode(item.InfoHash)
+		if err != nil {
+			logger.Err(err).Msg("error checking history")
+		}
+		events := d.Events()
+		publish := func(e Event) {
+			if !known {
+				events.Publish(e)
+			}
+		}
+		publish(episodeEvent(EventDiscovered, sub.ID, item))
+		if len(feed.Fallbacks) > 0 {
+			accept, err := d.DB.acceptQuality(feed, index, item)
+			if err != nil {
+				logger.Err(err).Msg("error checking quality fallback")
+				return nil
+			}
+			if !accept {
+				logger.Debug().Str("feed", feed.String()).Msg("holding back fallback quality release")
+				e := episodeEvent(EventFiltered, sub.ID, item)
+				e.Reason = "fallback quality held back"
+				publish(e)
+				return nil
+			}
+		}
+		publish(episodeEvent(EventQueued, sub.ID, item))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d.newItemCh <- queuedEpisode{Episode: item, subscription: sub.ID, known: known}:
+			logger.Debug().Interface("item", item).Msg("sent")
+		}
+		return nil
+	}
+}
+
+// handleItems adds the queued items to transmission. The events of an item
+// are published once its database transaction has been committed.
 func (d *ShowRSSDownloader) handleItems(ctx context.Context) error {
-loop:
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case item := <-d.newItemCh:
+		case queued := <-d.newItemCh:
+			item := queued.Episode
 			logger := getLogger(item)
 			logger.Debug().Msg("new item")
+			var events []Event
+			emit := func(e Event) { events = append(events, e) }
 			err := d.DB.Update(func(tx *bolt.Tx) error {
+				events = events[:0]
 				bucket := tx.Bucket(bucketAdded)
-				valueData := bucket.Get(item.Key())
+				key, err := item.Key()
+				if err != nil {
+					return err
+				}
+				valueData := bucket.Get(key)
 				found := valueData != nil
 				var dbep dbEpisode
 				if found {
@@ -127,57 +261,146 @@ loop:
 					}
 					dbep.Updated = time.Now()
 				} else {
-					var err error
 					dbep, err = newDBEpisode(item)
 					if err != nil {
 						return err
 					}
+					dbep.Subscription = queued.subscription
 				}
 				if !found {
-					logger.Debug().Msg("trying to add item to transmission")
-					err := d.addTorrent(ctx, item)
-					if err != nil {
-						if err == errAlreadyAdded {
-							logger.Debug().Msg("torrent already in transmission")
-						} else {
-							return fmt.Errorf("could not add torrent '%v' to transmission: %v", item, err)
-						}
-					} else {
-						logger.Info().Msg("torrent added to transmission")
+					if err := d.handleNewItem(ctx, tx, &dbep, emit); err != nil {
+						return err
 					}
-				} else {
+				} else if !queued.known {
+					// added since it was delivered, by another source or release
 					logger.Info().Msg("item already in added db")
+					e := episodeEvent(EventFiltered, queued.subscription, item)
+					e.Reason = "already in history"
+					emit(e)
 				}
 				data, err := json.Marshal(&dbep)
 				if err != nil {
 					return err
 				}
-				if err := bucket.Put(item.Key(), data); err != nil {
+				if err := bucket.Put(key, data); err != nil {
 					return err
 				}
 				return nil
 			})
 			if err != nil {
 				logger.Err(err).Msg("error handling item")
-				continue loop
+				e := episodeEvent(EventFailed, queued.subscription, item)
+				e.Error = err.Error()
+				d.Events().Publish(e)
+				continue
+			}
+			for _, e := range events {
+				d.Events().Publish(e)
+			}
+		}
+	}
+}
+
+// handleNewItem adds an item which is not in the added db to transmission,
+// unless the duplicate handling rejects it because another release of the
+// same episode has already been added. The resulting events are passed to
+// emit.
+func (d *ShowRSSDownloader) handleNewItem(ctx context.Context, tx *bolt.Tx, dbep *dbEpisode, emit func(Event)) error {
+	item := dbep.Episode
+	logger := getLogger(item)
+	add, replaces, reason, err := d.checkDuplicate(tx, item)
+	if err != nil {
+		return err
+	}
+	if !add {
+		logger.Info().Msg("another release of the episode has already been added")
+		dbep.State = EpisodeStateSkipped
+		e := episodeEvent(EventFiltered, dbep.Subscription, item)
+		e.Reason = "another release of the episode has already been added"
+		emit(e)
+		return nil
+	}
+	logger.Debug().Msg("trying to add item to transmission")
+	downloadDir := d.downloadDir(item)
+	err = d.addTorrent(ctx, item, downloadDir)
+	if err != nil {
+		if err != errAlreadyAdded {
+			return fmt.Errorf("could not add torrent '%v' to transmission: %v", item, err)
+		}
+		logger.Debug().Msg("torrent already in transmission")
+		e := episodeEvent(EventFiltered, dbep.Subscription, item)
+		e.Reason = "already in transmission"
+		emit(e)
+	} else {
+		logger.Info().Msg("torrent added to transmission")
+		e := episodeEvent(EventAdded, dbep.Subscription, item)
+		e.DownloadDir = d.episodeDownloadDir(item)
+		e.Replaces = replaces
+		e.Reason = reason
+		emit(e)
+	}
+	dbep.State = EpisodeStateAdded
+	if err := d.indexRelease(tx, item); err != nil {
+		return err
+	}
+	if replaces != "" {
+		logger := logger.With().Str("replaced_hash", replaces).Str("reason", reason).Logger()
+		dbep.Replaces = replaces
+		dbep.ReplaceReason = reason
+		if err := markReplaced(tx, replaces, item.InfoHash, reason); err != nil {
+			return err
+		}
+		if err := d.replaceTorrent(ctx, tx, replaces, item); err != nil {
+			logger.Err(err).Msg("could not remove replaced release from transmission")
+		} else {
+			logger.Info().Msg("replaced release of the episode")
+			if !d.deleteReplacedData() {
+				replaced := historyItem(tx, replaces)
+				e := episodeEvent(EventRemoved, replaced.Subscription, replaced.Episode)
+				e.Reason = "replaced by " + item.InfoHash
+				emit(e)
 			}
 		}
 	}
+	return nil
 }
 
 var errAlreadyAdded = errors.New("torrent already added")
 
-func (d *ShowRSSDownloader) addTorrent(ctx context.Context, item Episode) error {
+// downloadDir returns the transmission download directory of item, empty for
+// the session default.
+func (d *ShowRSSDownloader) downloadDir(item Episode) string {
+	if d.ShowDirs.Path == "" {
+		return ""
+	}
+	var root string
+	if !filepath.IsAbs(d.ShowDirs.Path) {
+		root = d.sessionDownloadDir
+	}
+	return filepath.Clean(filepath.Join(root, d.ShowDirs.Path, item.ShowDirectoryName()))
+}
+
+// episodeDownloadDir returns the directory transmission downloads item to.
+func (d *ShowRSSDownloader) episodeDownloadDir(item Episode) string {
+	if dir := d.downloadDir(item); dir != "" {
+		return dir
+	}
+	return d.sessionDownloadDir
+}
+
+func (d *ShowRSSDownloader) addTorrent(ctx context.Context, item Episode, downloadDir string) error {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	logger := getLogger(item)
 
 	infoHash := strings.ToLower(item.InfoHash)
+	start := time.Now()
 	torrents, err := d.TC.GetTorrents(ctx,
 		transmission.IDs(transmission.Hash(infoHash)),
 		transmission.TorrentFieldName, transmission.TorrentFieldHash,
 	)
+	observeRPC("torrent-get", start, err)
 	if err != nil {
 		return err
 	}
@@ -190,18 +413,12 @@ func (d *ShowRSSDownloader) addTorrent(ctx context.Context, item Episode) error
 			return errAlreadyAdded
 		}
 	}
-	var downloadDir string
-	if d.ShowDirs.Path != "" {
-		var root string
-		if !filepath.IsAbs(d.ShowDirs.Path) {
-			root = d.sessionDownloadDir
-		}
-		downloadDir = filepath.Clean(filepath.Join(root, d.ShowDirs.Path, item.ShowDirectoryName()))
-	}
+	start = time.Now()
 	_, err = d.TC.AddTorrent(context.Background(), &transmission.AddTorrentReq{
 		DownloadDirectory: String(downloadDir),
 		URL:               String(item.URL()),
 	})
+	observeRPC("torrent-add", start, err)
 	if err != nil {
 		logger.Err(err).Msg(spew.Sdump(torrents, item))
 		return err
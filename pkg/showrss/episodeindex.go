@@ -0,0 +1,124 @@
+package showrss
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DuplicatePolicy decides what happens when a different release of an episode
+// which has already been added shows up.
+type DuplicatePolicy string
+
+const (
+	DuplicateKeep    DuplicatePolicy = "keep"    // add the new release as well
+	DuplicateSkip    DuplicatePolicy = "skip"    // ignore the new release
+	DuplicateReplace DuplicatePolicy = "replace" // add the new release and remove the old one
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(s); p {
+	case DuplicateKeep, DuplicateSkip, DuplicateReplace:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown duplicate policy '%s'", s)
+}
+
+// DuplicateConfig configures the handling of releases of episodes which have
+// already been added. When ranking is enabled the rank decides instead of
+// Policy.
+type DuplicateConfig struct {
+	Policy     DuplicatePolicy
+	Repacks    bool // replace releases by a later PROPER or REPACK
+	DeleteData bool // delete the data of replaced torrents once the replacement has completed
+}
+
+var keyEpisodesIndexed = []byte("episodes_indexed")
+
+// migrateEpisodeIndex adds the items of the added bucket to the episode index
+// unless that has been done before. The most recently created item of each
+// episode is indexed.
+func (db *DB) migrateEpisodeIndex() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(keyEpisodesIndexed) != nil {
+			return nil
+		}
+		latest := make(map[string]dbEpisode)
+		err := tx.Bucket(bucketAdded).ForEach(func(k, v []byte) error {
+			var dbep dbEpisode
+			if err := json.Unmarshal(v, &dbep); err != nil {
+				log.Warn().Err(err).Str("info_hash", string(k)).Msg("could not decode added item")
+				return nil
+			}
+			dbep.Episode.parseTitle()
+			identity := dbep.Episode.Identity()
+			if identity == "" {
+				return nil
+			}
+			if prev, ok := latest[identity]; !ok || dbep.Created.After(prev.Created) {
+				latest[identity] = dbep
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for identity, dbep := range latest {
+			if entry, err := getEpisodeIndex(tx, identity); err != nil || entry != nil {
+				continue
+			}
+			if err := putEpisodeIndex(tx, identity, newEpisodeIndexEntry(dbep.Episode)); err != nil {
+				return err
+			}
+		}
+		log.Info().Int("episodes", len(latest)).Msg("migrated added items into the episode index")
+		return meta.Put(keyEpisodesIndexed, []byte("1"))
+	})
+}
+
+func newEpisodeIndexEntry(item Episode) episodeIndexEntry {
+	return episodeIndexEntry{
+		InfoHash: item.InfoHash,
+		Title:    item.Title,
+		Release:  item.Release,
+		Updated:  time.Now(),
+	}
+}
+
+// checkDuplicate compares item with the release already added for the same
+// episode. It returns false if item should not be added, otherwise the info
+// hash of the release which item replaces, if any, and the reason.
+func (d *ShowRSSDownloader) checkDuplicate(tx *bolt.Tx, item Episode) (add bool, replaces, reason string, err error) {
+	identity := item.Identity()
+	if identity == "" {
+		return true, "", "", nil
+	}
+	entry, err := getEpisodeIndex(tx, identity)
+	if err != nil || entry == nil {
+		return true, "", "", err
+	}
+	if entry.InfoHash == item.InfoHash {
+		return true, "", "", nil
+	}
+	if d.Duplicates.Repacks && isRepackOf(item, entry) &&
+		(!d.Ranking.Enabled || d.compareEntry(item, entry) >= 0) {
+		return true, entry.InfoHash, ReplaceReasonRepack, nil
+	}
+	if d.Ranking.Enabled {
+		if d.compareEntry(item, entry) <= 0 {
+			return false, "", "", nil
+		}
+		return true, entry.InfoHash, ReplaceReasonRank, nil
+	}
+	switch d.Duplicates.Policy {
+	case DuplicateSkip:
+		return false, "", "", nil
+	case DuplicateReplace:
+		return true, entry.InfoHash, ReplaceReasonPolicy, nil
+	}
+	return true, "", "", nil
+}
@@ -0,0 +1,102 @@
+package showrss
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EpisodeNumber identifies an episode within a show.
+type EpisodeNumber struct {
+	Season   int    `json:"season,omitempty"`
+	Episodes []int  `json:"episodes,omitempty"` // more than one for multi episode releases
+	AirDate  string `json:"air_date,omitempty"` // 2006-01-02, for daily shows
+	Special  bool   `json:"special,omitempty"`
+}
+
+// IsZero reports whether nothing could be parsed.
+func (n EpisodeNumber) IsZero() bool {
+	return n.Season == 0 && len(n.Episodes) == 0 && n.AirDate == "" && !n.Special
+}
+
+func (n EpisodeNumber) String() string {
+	if n.AirDate != "" {
+		return n.AirDate
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "S%02d", n.Season)
+	for _, e := range n.Episodes {
+		fmt.Fprintf(&sb, "E%02d", e)
+	}
+	return sb.String()
+}
+
+var (
+	// S01E01, S01E01E02, S01E01-E03, S01E01-03, s1.e1
+	seRe      = regexp.MustCompile(`(?i)\bs(\d{1,3})[ ._]?e(\d{1,4})((?:[-_ .]?e\d{1,4})*)(?:-(\d{1,4})\b)?`)
+	seMoreRe  = regexp.MustCompile(`(?i)([-_ .]?)e(\d{1,4})`)
+	xRe       = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})(?:-(?:\d{1,2}x)?(\d{2,3}))?\b`)
+	dateRe    = regexp.MustCompile(`\b((?:19|20)\d{2})[.\- ](\d{2})[.\- ](\d{2})\b`)
+	specialRe = regexp.MustCompile(`(?i)\b(special|ova)\b`)
+)
+
+// ParseEpisodeNumber parses the season and episode numbers or the air date of
+// daily shows from a release title. Season 0 and episode 0 releases are
+// specials, as are releases with a special or OVA token after the start of
+// the episode number, or anywhere in a title without a number. Callers strip
+// the show name from the title if it is known, so that show names like
+// "Law & Order: Special Victims Unit" do not make every episode a special.
+func ParseEpisodeNumber(title string) EpisodeNumber {
+	var n EpisodeNumber
+	rest := title // the part of the title which may mark a special
+	if m := seRe.FindStringSubmatchIndex(title); m != nil {
+		rest = title[m[0]:]
+		n.Season = atoi(title[m[2]:m[3]])
+		n.Episodes = []int{atoi(title[m[4]:m[5]])}
+		for _, more := range seMoreRe.FindAllStringSubmatch(title[m[6]:m[7]], -1) {
+			n.Episodes = appendEpisode(n.Episodes, more[1] == "-", atoi(more[2]))
+		}
+		if m[8] >= 0 {
+			n.Episodes = appendEpisode(n.Episodes, true, atoi(title[m[8]:m[9]]))
+		}
+	} else if m := xRe.FindStringSubmatchIndex(title); m != nil {
+		rest = title[m[0]:]
+		n.Season = atoi(title[m[2]:m[3]])
+		n.Episodes = []int{atoi(title[m[4]:m[5]])}
+		if m[6] >= 0 {
+			n.Episodes = appendEpisode(n.Episodes, true, atoi(title[m[6]:m[7]]))
+		}
+	} else if m := dateRe.FindStringSubmatchIndex(title); m != nil {
+		rest = title[m[0]:]
+		date := fmt.Sprintf("%s-%s-%s", title[m[2]:m[3]], title[m[4]:m[5]], title[m[6]:m[7]])
+		if _, err := time.Parse("2006-01-02", date); err == nil {
+			n.AirDate = date
+		}
+	}
+	if len(n.Episodes) > 0 && (n.Season == 0 || n.Episodes[0] == 0) || specialRe.MatchString(rest) {
+		n.Special = true
+	}
+	return n
+}
+
+// appendEpisode appends e to episodes, filling in the episodes in between if
+// e ends a range.
+func appendEpisode(episodes []int, isRange bool, e int) []int {
+	last := episodes[len(episodes)-1]
+	if e <= last {
+		return episodes
+	}
+	if isRange {
+		for i := last + 1; i < e; i++ {
+			episodes = append(episodes, i)
+		}
+	}
+	return append(episodes, e)
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,38 @@
+package showrss
+
+import "testing"
+
+func TestParseEpisodeNumberSpecial(t *testing.T) {
+	tests := []struct {
+		title   string
+		number  string
+		special bool
+	}{
+		{"Law & Order: Special Victims Unit S25E03 720p WEB h264", "S25E03", false},
+		{"Law.and.Order.Special.Victims.Unit.S25E03.1080p.WEB.h264", "S25E03", false},
+		{"Doctor Who S00E150 The Church on Ruby Road", "S00E150", true},
+		{"Show S02E00 720p HDTV", "S02E00", true},
+		{"Show S02E05 Christmas Special 720p", "S02E05", true},
+		{"Show 2x05 720p", "S02E05", false},
+		{"Anime OVA 1080p", "S00", true},
+	}
+	for _, tt := range tests {
+		n := ParseEpisodeNumber(tt.title)
+		if n.String() != tt.number || n.Special != tt.special {
+			t.Errorf("%s: %s special %v, want %s special %v", tt.title, n, n.Special, tt.number, tt.special)
+		}
+	}
+}
+
+func TestParseTitleTrimsShowName(t *testing.T) {
+	ep := Episode{Title: "Law & Order: Special Victims Unit 2x05", ShowName: "Law & Order: Special Victims Unit"}
+	ep.parseTitle()
+	if ep.Number.Special {
+		t.Errorf("%s: special", ep.Title)
+	}
+	ep = Episode{Title: "Law & Order: Special Victims Unit", ShowName: "Law & Order: Special Victims Unit"}
+	ep.parseTitle()
+	if ep.Number.Special {
+		t.Errorf("%s: special", ep.Title)
+	}
+}
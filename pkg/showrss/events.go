@@ -0,0 +1,222 @@
+package showrss
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+// EventType is the kind of a downloader event.
+type EventType string
+
+// Episode lifecycle events. An episode delivered by a feed source is
+// discovered, then either filtered or queued. A queued episode is filtered,
+// added or failed. Added episodes are completed when transmission has
+// finished downloading them and removed when their torrent is removed from
+// transmission or the episode is removed from the history.
+const (
+	EventFeedPolled EventType = "feed_polled" // a feed source was fetched
+
+	EventDiscovered EventType = "episode_discovered" // a feed source delivered the episode
+	EventFiltered   EventType = "episode_filtered"   // the episode will not be added, see Reason
+	EventQueued     EventType = "episode_queued"     // the episode passed the quality rules and waits to be handled
+	EventAdded      EventType = "episode_added"      // the torrent was added to transmission
+	EventFailed     EventType = "episode_failed"     // handling the episode failed, see Error
+	EventCompleted  EventType = "episode_completed"  // transmission finished downloading the torrent
+	EventRemoved    EventType = "episode_removed"    // the torrent or history item was removed, see Reason
+
+	EventAlertFiring   EventType = "alert_firing"   // an alert rule broke, see Alert and Reason
+	EventAlertResolved EventType = "alert_resolved" // a firing alert cleared
+)
+
+// episodeEventTypes are the episode lifecycle event types.
+var episodeEventTypes = []EventType{
+	EventDiscovered,
+	EventFiltered,
+	EventQueued,
+	EventAdded,
+	EventFailed,
+	EventCompleted,
+	EventRemoved,
+}
+
+// alertEventTypes are the alert event types.
+var alertEventTypes = []EventType{EventAlertFiring, EventAlertResolved}
+
+// EventTypes are all event types.
+var EventTypes = append(append([]EventType{EventFeedPolled}, episodeEventTypes...), alertEventTypes...)
+
+// Event is something the downloader did.
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	Source string `json:"source,omitempty"` // feed source
+	Result string `json:"result,omitempty"` // fetch result: ok, not_modified or error
+	Items  int    `json:"items,omitempty"`  // number of items in a fetched feed
+
+	Subscription string   `json:"subscription,omitempty"` // id of the subscription delivering the episode
+	Episode      *Episode `json:"episode,omitempty"`
+	DownloadDir  string   `json:"download_dir,omitempty"` // transmission download directory of added episodes
+	Replaces     string   `json:"replaces,omitempty"`     // info hash of the release replaced by an added episode
+	Alert        string   `json:"alert,omitempty"`        // alert rule of alert events
+	Reason       string   `json:"reason,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// episodeEvent returns an event of typ about item.
+func episodeEvent(typ EventType, subscription string, item Episode) Event {
+	return Event{
+		Type:         typ,
+		Subscription: subscription,
+		Episode:      &item,
+	}
+}
+
+const (
+	eventHistorySize   = 100 // events kept for clients resuming a stream
+	eventSubscriberBuf = 64
+	eventHandlerBuf    = 1024
+)
+
+type eventSubscriber struct {
+	name  string
+	types map[EventType]bool // all types if empty
+	ch    chan Event
+}
+
+// EventHub fans out events to subscribers. Publishing never blocks, events
+// are dropped for subscribers which do not keep up. A nil *EventHub drops all
+// events.
+type EventHub struct {
+	mu      sync.Mutex
+	nextID  uint64
+	history []Event // ring buffer of the latest events
+	subs    map[*eventSubscriber]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subs: make(map[*eventSubscriber]struct{})}
+}
+
+// Publish assigns an id and time to e and sends it to all subscribers.
+func (h *EventHub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	e.ID = h.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if len(h.history) < eventHistorySize {
+		h.history = append(h.history, e)
+	} else {
+		h.history[int(e.ID-1)%eventHistorySize] = e
+	}
+	for sub := range h.subs {
+		if len(sub.types) > 0 && !sub.types[e.Type] {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			metricEventsDropped.add(1, sub.name)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all events published after the call
+// and a function which ends the subscription. Name identifies the subscriber
+// in metrics.
+func (h *EventHub) Subscribe(name string) (<-chan Event, func()) {
+	return h.subscribe(name, eventSubscriberBuf, nil, nil)
+}
+
+// SubscribeAfter is like Subscribe but first replays the kept events with an
+// id greater than id, for clients resuming a stream.
+func (h *EventHub) SubscribeAfter(name string, id uint64) (<-chan Event, func()) {
+	return h.subscribe(name, eventSubscriberBuf, &id, nil)
+}
+
+// Handle calls fn for each published event of the given types, or of all
+// types if none are given, until the returned function is called. Fn is
+// called from a single goroutine per handler so a slow handler only delays
+// its own events.
+func (h *EventHub) Handle(name string, fn func(Event), types ...EventType) func() {
+	ch, cancel := h.subscribe(name, eventHandlerBuf, nil, types)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case e := <-ch:
+				callHandler(name, fn, e)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			close(done)
+		})
+	}
+}
+
+// callHandler calls fn with e, a panicking handler does not take down the
+// downloader.
+func callHandler(name string, fn func(Event), e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Str("handler", name).Interface("panic", r).Msg("event handler panicked")
+		}
+	}()
+	fn(e)
+}
+
+func (h *EventHub) subscribe(name string, buf int, after *uint64, types []EventType) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var missed []Event
+	if after != nil {
+		for _, e := range h.history {
+			if e.ID > *after {
+				missed = append(missed, e)
+			}
+		}
+		sort.Slice(missed, func(i, j int) bool { return missed[i].ID < missed[j].ID })
+	}
+	sub := &eventSubscriber{name: name, ch: make(chan Event, buf+len(missed))}
+	if len(types) > 0 {
+		sub.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	for _, e := range missed {
+		sub.ch <- e
+	}
+	h.subs[sub] = struct{}{}
+	return sub.ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs, sub)
+	}
+}
+
+// Events returns the event bus of the downloader.
+func (d *ShowRSSDownloader) Events() *EventHub {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.events == nil {
+		d.events = NewEventHub()
+	}
+	return d.events
+}
@@ -0,0 +1,75 @@
+package showrss
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type fallbackRecord struct {
+	FirstSeen time.Time `json:"first_seen"`
+	Accepted  []Quality `json:"accepted"`
+}
+
+func (r fallbackRecord) accepted(q Quality) bool {
+	for _, v := range r.Accepted {
+		if v == q {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptQuality reports whether item, received from the feed at position index
+// in the quality chain of feed, should be passed on for downloading.
+//
+// The preferred quality is always accepted. A fallback quality is accepted
+// when no release of a more preferred quality has been accepted for the same
+// episode and the episode was first seen longer ago than the fallback delay.
+// Held back items are re-evaluated each time the feed is polled.
+func (db *DB) acceptQuality(feed Feed, index int, item Episode) (bool, error) {
+	if len(feed.Fallbacks) == 0 || item.EpisodeID == "" {
+		return true, nil
+	}
+	qualities := feed.Qualities()
+	quality := qualities[index]
+	key := []byte(string(feed.Key()) + "/" + item.EpisodeID)
+	var accept bool
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketFallback)
+		var rec fallbackRecord
+		if data := bucket.Get(key); data != nil {
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+		} else {
+			rec.FirstSeen = time.Now()
+		}
+		switch {
+		case rec.accepted(quality):
+			accept = true
+		case index == 0:
+			accept = true
+		default:
+			accept = time.Since(rec.FirstSeen) >= feed.Fallbacks[index-1].Delay
+			for _, q := range qualities[:index] {
+				if rec.accepted(q) {
+					accept = false
+				}
+			}
+		}
+		if accept && !rec.accepted(quality) {
+			rec.Accepted = append(rec.Accepted, quality)
+		}
+		data, err := json.Marshal(&rec)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+	if err != nil {
+		return false, err
+	}
+	return accept, nil
+}
@@ -2,10 +2,13 @@ package showrss
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
 )
 
 // RSS .
@@ -15,13 +18,15 @@ type rss struct {
 }
 
 type Channel struct {
-	XMLName  xml.Name  `xml:"channel"`
-	Title    string    `xml:"title"`
-	TTL      int       `xml:"ttl"`
-	Episodes []Episode `xml:"item"`
+	XMLName  xml.Name  `xml:"channel" json:"-"`
+	Title    string    `xml:"title" json:"title"`
+	TTL      int       `xml:"ttl" json:"ttl"`
+	Episodes []Episode `xml:"item" json:"episodes"`
 
-	URL string // request url
+	URL         string `xml:"-" json:"url"` // request url
+	NotModified bool   `xml:"-" json:"-"`   // the feed is unchanged, the episodes are those of the last handled response
 
+	validators feedValidators // http cache validators of the response
 }
 
 func (c Channel) TTLDuration() time.Duration {
@@ -32,6 +37,53 @@ func (c Channel) TTLDuration() time.Duration {
 type Enclosure struct {
 	MimeType string `xml:"type,attr" json:"type"`
 	URL      string `xml:"url,attr" json:"attr"`
+	Length   int64  `xml:"length,attr" json:"length,omitempty"`
+}
+
+// FeedTime is a feed date. It is parsed from the RFC 822 dates used by RSS
+// and the RFC 3339 dates used by Atom.
+type FeedTime struct {
+	time.Time
+}
+
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+}
+
+// ParseFeedTime parses a feed date in any of the commonly used formats.
+func ParseFeedTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format '%s'", s)
+}
+
+// UnmarshalXML leaves the time zero if the date can not be parsed.
+func (t *FeedTime) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+	parsed, err := ParseFeedTime(s)
+	if err != nil {
+		return nil
+	}
+	t.Time = parsed
+	return nil
 }
 
 // Episode .
@@ -44,26 +96,86 @@ type Episode struct {
 	ShowName   string      `xml:"show_name" json:"show_name"`
 	EpisodeID  string      `xml:"episode_id" json:"episode_id"`
 	RawTitle   string      `xml:"raw_title" json:"raw_title"`
+	PubDate    FeedTime    `xml:"pubDate" json:"pub_date"`
+	GUID       string      `xml:"guid" json:"guid,omitempty"`
+	Link       string      `xml:"link" json:"link,omitempty"`
+
+	Size    int64         `xml:"-" json:"size,omitempty"` // length of the torrent enclosure
+	Number  EpisodeNumber `xml:"-" json:"number"`         // parsed from RawTitle or Title
+	Release Release       `xml:"-" json:"release"`        // parsed from RawTitle or Title
 }
 
 func (i Episode) String() string {
 	return fmt.Sprintf("%s (%s)", i.InfoHash, i.Title)
 }
 
-func (i Episode) Key() []byte {
+// ErrNoInfoHash is returned for episodes without an info hash, which can not
+// be stored.
+var ErrNoInfoHash = errors.New("info hash is empty, no key possible")
+
+func (i Episode) Key() ([]byte, error) {
 	if i.InfoHash == "" {
-		log.Fatal("InfoHash is empty, no key possible")
+		return nil, ErrNoInfoHash
 	}
-	return []byte(i.InfoHash)
+	return []byte(i.InfoHash), nil
 }
 
 func (i Episode) URL() string {
+	return i.torrentEnclosure().URL
+}
+
+func (i Episode) torrentEnclosure() Enclosure {
 	for _, e := range i.Enclosures {
-		if e.MimeType == "application/x-bittorrent" {
-			return e.URL
+		if e.MimeType == mimeTypeBittorrent {
+			return e
 		}
 	}
-	return ""
+	return Enclosure{}
+}
+
+// parseTitle parses Number and Release from RawTitle, falling back to Title.
+func (i *Episode) parseTitle() {
+	title := i.RawTitle
+	if title == "" {
+		title = i.Title
+	}
+	i.Number = ParseEpisodeNumber(trimShowName(title, i.ShowName))
+	if i.Number.IsZero() {
+		i.Number = ParseEpisodeNumber(trimShowName(i.Title, i.ShowName))
+	}
+	i.Release = ParseRelease(title)
+}
+
+// trimShowName removes the show name from the start of a release title. Dots
+// and underscores in the title match spaces in the name.
+func trimShowName(title, showName string) string {
+	if showName == "" || len(title) < len(showName) {
+		return title
+	}
+	normalize := strings.NewReplacer(".", " ", "_", " ").Replace
+	if strings.EqualFold(normalize(title[:len(showName)]), normalize(showName)) {
+		return title[len(showName):]
+	}
+	return title
+}
+
+// Identity identifies the episode independent of the release, or returns an
+// empty string if the show or episode number is unknown.
+func (i Episode) Identity() string {
+	n := i.Number
+	if n.AirDate == "" && len(n.Episodes) == 0 {
+		return ""
+	}
+	var show string
+	switch {
+	case i.ShowID != 0:
+		show = strconv.Itoa(i.ShowID)
+	case i.ShowName != "":
+		show = strings.ToLower(i.ShowName)
+	default:
+		return ""
+	}
+	return show + "/" + n.String()
 }
 
 func (i Episode) ShowDirectoryName() string {
@@ -75,32 +187,43 @@ func (i Episode) ShowDirectoryName() string {
 	return s
 }
 
+// FeedError is returned when a feed is well formed but can not be used.
 type FeedError string
 
 func (fe FeedError) Error() string {
 	return string(fe)
 }
 
+// defaultTTL is the feed ttl in minutes used when a feed has no ttl.
+const defaultTTL = 15
+
 func ParseRSS(data []byte) (*Channel, error) {
 	var rss rss
 
-	if rss.Channel.TTL == 0 {
-		rss.Channel.TTL = 15
-	}
-
-	if err := xml.Unmarshal(data, &rss); err != nil {
+	if err := unmarshalXML(data, &rss); err != nil {
 		return nil, err
 	}
+	if rss.Channel.TTL <= 0 {
+		rss.Channel.TTL = defaultTTL
+	}
 	if rss.Channel.Title == "" {
 		return &rss.Channel, FeedError("channel has no title")
 	}
 
-	if len(rss.Channel.Episodes) == 0 {
-		return &rss.Channel, FeedError("no episodes")
-	}
-	for k, v := range rss.Channel.Episodes {
+	episodes := rss.Channel.Episodes[:0]
+	for _, v := range rss.Channel.Episodes {
+		if v.InfoHash == "" {
+			v.InfoHash = infoHashFromMagnet(v.Link)
+		}
+		if v.InfoHash == "" {
+			log.Debug().Str("title", v.Title).Msg("skipping feed item without info hash")
+			continue
+		}
 		v.InfoHash = strings.ToLower(v.InfoHash)
-		rss.Channel.Episodes[k] = v
+		v.Size = v.torrentEnclosure().Length
+		v.parseTitle()
+		episodes = append(episodes, v)
 	}
+	rss.Channel.Episodes = episodes
 	return &rss.Channel, nil
 }
@@ -0,0 +1,105 @@
+package showrss
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrorClass is the kind of a feed fetch error. It decides how soon a failed
+// feed is retried, semantic errors are retried at the longest interval.
+type ErrorClass string
+
+const (
+	ErrorClassTransient ErrorClass = "transient" // network errors, timeouts, 429 and 5xx responses
+	ErrorClassMalformed ErrorClass = "malformed" // the response is not a parseable feed
+	ErrorClassSemantic  ErrorClass = "semantic"  // the request was rejected or the feed can not be used
+)
+
+// ClassifyError returns the class of an error returned by a feed fetch.
+func ClassifyError(err error) ErrorClass {
+	var (
+		he *HTTPError
+		me *MalformedFeedError
+		fe FeedError
+	)
+	switch {
+	case errors.As(err, &he):
+		if he.Temporary() {
+			return ErrorClassTransient
+		}
+		return ErrorClassSemantic
+	case errors.As(err, &me), errors.Is(err, ErrBodyTooLarge):
+		return ErrorClassMalformed
+	case errors.As(err, &fe):
+		return ErrorClassSemantic
+	}
+	return ErrorClassTransient
+}
+
+// MalformedFeedError is returned when a feed response can not be decoded.
+type MalformedFeedError struct {
+	Err error
+}
+
+func (e *MalformedFeedError) Error() string {
+	return fmt.Sprintf("malformed feed: %v", e.Err)
+}
+
+func (e *MalformedFeedError) Unwrap() error {
+	return e.Err
+}
+
+// newXMLDecoder returns a decoder for data which supports the charsets
+// commonly used by feeds besides UTF-8.
+func newXMLDecoder(data []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = charsetReader
+	return dec
+}
+
+// unmarshalXML decodes data into v, returning a MalformedFeedError if data is
+// not valid xml.
+func unmarshalXML(data []byte, v interface{}) error {
+	if err := newXMLDecoder(data).Decode(v); err != nil {
+		return &MalformedFeedError{Err: err}
+	}
+	return nil
+}
+
+// windows1252 maps the bytes 0x80 to 0x9f of windows-1252 which differ from
+// ISO-8859-1.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	var cp1252 bool
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1", "l1":
+	case "windows-1252", "cp1252":
+		cp1252 = true
+	default:
+		return nil, fmt.Errorf("unsupported charset '%s'", charset)
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(data)*2)
+	for _, b := range data {
+		r := rune(b)
+		if cp1252 && b >= 0x80 && b <= 0x9f {
+			r = windows1252[b-0x80]
+		}
+		buf = utf8.AppendRune(buf, r)
+	}
+	return bytes.NewReader(buf), nil
+}
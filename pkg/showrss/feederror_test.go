@@ -0,0 +1,85 @@
+package showrss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{errors.New("connection refused"), ErrorClassTransient},
+		{context.DeadlineExceeded, ErrorClassTransient},
+		{&HTTPError{StatusCode: 429}, ErrorClassTransient},
+		{&HTTPError{StatusCode: 502}, ErrorClassTransient},
+		{&HTTPError{StatusCode: 404}, ErrorClassSemantic},
+		{&HTTPError{StatusCode: 401}, ErrorClassSemantic},
+		{fmt.Errorf("fetch: %w", &HTTPError{StatusCode: 403}), ErrorClassSemantic},
+		{&MalformedFeedError{Err: errors.New("EOF")}, ErrorClassMalformed},
+		{ErrBodyTooLarge, ErrorClassMalformed},
+		{FeedError("channel has no title"), ErrorClassSemantic},
+	}
+	for _, tt := range tests {
+		if class := ClassifyError(tt.err); class != tt.class {
+			t.Errorf("%v: class %s, want %s", tt.err, class, tt.class)
+		}
+	}
+}
+
+func TestParseRSSErrors(t *testing.T) {
+	tests := []struct {
+		data  string
+		class ErrorClass
+	}{
+		{`<html><body>`, ErrorClassMalformed},
+		{`not xml at all`, ErrorClassMalformed},
+		{`<?xml version="1.0" encoding="koi8-r"?><rss><channel><title>x</title></channel></rss>`, ErrorClassMalformed},
+		{`<rss><channel></channel></rss>`, ErrorClassSemantic},
+	}
+	for _, tt := range tests {
+		_, err := ParseRSS([]byte(tt.data))
+		if err == nil {
+			t.Errorf("%s: no error", tt.data)
+			continue
+		}
+		if class := ClassifyError(err); class != tt.class {
+			t.Errorf("%s: %v: class %s, want %s", tt.data, err, class, tt.class)
+		}
+	}
+}
+
+func TestParseRSSLenient(t *testing.T) {
+	data := "<?xml version=\"1.0\" encoding=\"windows-1252\"?>\n" +
+		"<rss><channel><title>Caf\xe9 \x93feed\x94</title>\n" +
+		"<item><title>Show S01E01</title><pubDate>Mon, 2 Jan 2006 15:04:05 MST</pubDate>" +
+		"<link>magnet:?xt=urn:btih:0123456789ABCDEF0123456789ABCDEF01234567</link></item>\n" +
+		"<item><title>Show S01E02</title><pubDate>yesterday</pubDate>" +
+		"<tv:info_hash xmlns:tv=\"http://showrss.info\">ABCDEF0123456789ABCDEF0123456789ABCDEF01</tv:info_hash></item>\n" +
+		"<item><title>Show S01E03 without hash</title></item>\n" +
+		"</channel></rss>"
+	ch, err := ParseRSS([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Title != "Café “feed”" {
+		t.Errorf("title %q", ch.Title)
+	}
+	if ch.TTL != defaultTTL {
+		t.Errorf("ttl %d, want %d", ch.TTL, defaultTTL)
+	}
+	if len(ch.Episodes) != 2 {
+		t.Fatalf("%d episodes, want 2", len(ch.Episodes))
+	}
+	if ep := ch.Episodes[0]; ep.InfoHash != testHash || !ep.PubDate.Equal(time.Date(2006, 1, 2, 15, 4, 5, 0, ep.PubDate.Location())) {
+		t.Errorf("episode %s pub date %v", ep.InfoHash, ep.PubDate)
+	}
+	// unparseable dates are left zero
+	if ep := ch.Episodes[1]; ep.InfoHash != "abcdef0123456789abcdef0123456789abcdef01" || !ep.PubDate.IsZero() {
+		t.Errorf("episode %s pub date %v", ep.InfoHash, ep.PubDate)
+	}
+}
@@ -0,0 +1,269 @@
+package showrss
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+const mimeTypeBittorrent = "application/x-bittorrent"
+
+// genericRSS is a RSS 2.0 document, possibly with torrent or torznab
+// namespace extensions.
+type genericRSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel struct {
+		Title string        `xml:"title"`
+		TTL   int           `xml:"ttl"`
+		Items []genericItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type genericItem struct {
+	Title      string        `xml:"title"`
+	Link       string        `xml:"link"`
+	GUID       string        `xml:"guid"`
+	PubDate    FeedTime      `xml:"pubDate"`
+	Size       string        `xml:"size"`
+	InfoHash   string        `xml:"infoHash"`
+	MagnetURI  string        `xml:"magnetURI"`
+	Enclosures []Enclosure   `xml:"enclosure"`
+	Attrs      []torznabAttr `xml:"attr"`
+}
+
+type torznabAttr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func (i genericItem) attr(name string) string {
+	for _, a := range i.Attrs {
+		if strings.EqualFold(a.Name, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Published FeedTime   `xml:"published"`
+	Updated   FeedTime   `xml:"updated"`
+	InfoHash  string     `xml:"infoHash"`
+	Links     []atomLink `xml:"link"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type torznabError struct {
+	XMLName     xml.Name `xml:"error"`
+	Code        string   `xml:"code,attr"`
+	Description string   `xml:"description,attr"`
+}
+
+// ParseGenericFeed parses a RSS 2.0 or Atom feed. Items without an info hash
+// or magnet link are skipped.
+func ParseGenericFeed(data []byte) (*Channel, error) {
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+	switch root {
+	case "rss":
+		return parseGenericRSS(data)
+	case "feed":
+		return parseAtom(data)
+	}
+	return nil, FeedError(fmt.Sprintf("unknown feed format '%s'", root))
+}
+
+// ParseTorznab parses a Torznab api response.
+func ParseTorznab(data []byte) (*Channel, error) {
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+	if root == "error" {
+		var te torznabError
+		if err := unmarshalXML(data, &te); err != nil {
+			return nil, err
+		}
+		return nil, FeedError(fmt.Sprintf("torznab error %s: %s", te.Code, te.Description))
+	}
+	return parseGenericRSS(data)
+}
+
+func rootElement(data []byte) (string, error) {
+	dec := newXMLDecoder(data)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", &MalformedFeedError{Err: err}
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local, nil
+		}
+	}
+}
+
+func parseGenericRSS(data []byte) (*Channel, error) {
+	var doc genericRSS
+	if err := unmarshalXML(data, &doc); err != nil {
+		return nil, err
+	}
+	channel := &Channel{
+		Title: doc.Channel.Title,
+		TTL:   doc.Channel.TTL,
+	}
+	for _, item := range doc.Channel.Items {
+		infoHash := item.attr("infohash")
+		if infoHash == "" {
+			infoHash = item.InfoHash
+		}
+		links := []string{item.attr("magneturl"), item.MagnetURI, item.Link, item.GUID}
+		var torrentURL string
+		size, _ := strconv.ParseInt(strings.TrimSpace(item.Size), 10, 64)
+		for _, e := range item.Enclosures {
+			links = append(links, e.URL)
+			if e.MimeType == mimeTypeBittorrent && torrentURL == "" {
+				torrentURL = e.URL
+				if size == 0 {
+					size = e.Length
+				}
+			}
+		}
+		if v, err := strconv.ParseInt(item.attr("size"), 10, 64); err == nil {
+			size = v
+		}
+		if ep, ok := newGenericEpisode(item.Title, infoHash, links, torrentURL); ok {
+			ep.PubDate = item.PubDate
+			ep.GUID = item.GUID
+			ep.Link = item.Link
+			ep.Size = size
+			channel.Episodes = append(channel.Episodes, ep)
+		}
+	}
+	return finishGenericChannel(channel), nil
+}
+
+func parseAtom(data []byte) (*Channel, error) {
+	var doc atomFeed
+	if err := unmarshalXML(data, &doc); err != nil {
+		return nil, err
+	}
+	channel := &Channel{
+		Title: doc.Title,
+	}
+	for _, entry := range doc.Entries {
+		links := []string{entry.ID}
+		var torrentURL, link string
+		for _, l := range entry.Links {
+			links = append(links, l.Href)
+			if l.Type == mimeTypeBittorrent && torrentURL == "" {
+				torrentURL = l.Href
+			}
+			if (l.Rel == "" || l.Rel == "alternate") && link == "" {
+				link = l.Href
+			}
+		}
+		if ep, ok := newGenericEpisode(entry.Title, entry.InfoHash, links, torrentURL); ok {
+			ep.PubDate = entry.Published
+			if ep.PubDate.IsZero() {
+				ep.PubDate = entry.Updated
+			}
+			ep.GUID = entry.ID
+			ep.Link = link
+			channel.Episodes = append(channel.Episodes, ep)
+		}
+	}
+	return finishGenericChannel(channel), nil
+}
+
+func finishGenericChannel(channel *Channel) *Channel {
+	if channel.TTL <= 0 {
+		channel.TTL = defaultTTL
+	}
+	return channel
+}
+
+// newGenericEpisode maps a feed item to an Episode. The info hash is taken
+// from infoHash if set, otherwise from the first magnet link in links. The
+// episode is downloaded from a magnet link if there is one, otherwise from
+// torrentURL. Returns false if no info hash could be found.
+func newGenericEpisode(title, infoHash string, links []string, torrentURL string) (Episode, bool) {
+	var magnet string
+	for _, l := range links {
+		if strings.HasPrefix(l, "magnet:") {
+			magnet = l
+			break
+		}
+	}
+	if infoHash == "" && magnet != "" {
+		infoHash = infoHashFromMagnet(magnet)
+	}
+	infoHash = strings.ToLower(infoHash)
+	if infoHash == "" {
+		log.Debug().Str("title", title).Msg("skipping feed item without info hash")
+		return Episode{}, false
+	}
+	downloadURL := magnet
+	if downloadURL == "" {
+		downloadURL = torrentURL
+	}
+	if downloadURL == "" {
+		downloadURL = fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s", infoHash, url.QueryEscape(title))
+	}
+	ep := Episode{
+		Title:    title,
+		RawTitle: title,
+		InfoHash: infoHash,
+		Enclosures: []Enclosure{
+			{MimeType: mimeTypeBittorrent, URL: downloadURL},
+		},
+	}
+	ep.parseTitle()
+	return ep, true
+}
+
+// infoHashFromMagnet returns the hex encoded info hash of a magnet link, or
+// an empty string if the link has no bittorrent info hash.
+func infoHashFromMagnet(magnet string) string {
+	u, err := url.Parse(magnet)
+	if err != nil {
+		return ""
+	}
+	for _, xt := range u.Query()["xt"] {
+		if !strings.HasPrefix(strings.ToLower(xt), "urn:btih:") {
+			continue
+		}
+		h := xt[len("urn:btih:"):]
+		switch len(h) {
+		case 40:
+			if _, err := hex.DecodeString(h); err == nil {
+				return strings.ToLower(h)
+			}
+		case 32:
+			if b, err := base32.StdEncoding.DecodeString(strings.ToUpper(h)); err == nil {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+	return ""
+}
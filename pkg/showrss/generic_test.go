@@ -0,0 +1,127 @@
+package showrss
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	testHash    = "0123456789abcdef0123456789abcdef01234567"
+	testMagnet  = "magnet:?xt=urn:btih:0123456789ABCDEF0123456789ABCDEF01234567"
+	testBase32  = "magnet:?xt=urn:btih:AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH"
+	testTorrent = "https://example.com/show.torrent"
+)
+
+func TestParseGenericRSS(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:torrent="http://xmlns.ezrss.it/0.1/">
+<channel>
+<title>Generic</title>
+<item>
+<title>Show S01E02 1080p WEB-DL x264-GROUP</title>
+<link>https://example.com/show</link>
+<pubDate>Mon, 02 Jan 2006 15:04:05 -0700</pubDate>
+<enclosure type="application/x-bittorrent" url="` + testTorrent + `" length="1234"/>
+<torrent:infoHash>0123456789ABCDEF0123456789ABCDEF01234567</torrent:infoHash>
+</item>
+<item>
+<title>Show S01E03 720p HDTV x264-GROUP</title>
+<torrent:magnetURI>` + testBase32 + `</torrent:magnetURI>
+</item>
+<item>
+<title>Show S01E04 without hash</title>
+<link>https://example.com/show</link>
+</item>
+</channel>
+</rss>`
+	ch, err := ParseGenericFeed([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Title != "Generic" || ch.TTL != defaultTTL {
+		t.Errorf("channel %q ttl %d", ch.Title, ch.TTL)
+	}
+	if len(ch.Episodes) != 2 {
+		t.Fatalf("%d episodes, want 2", len(ch.Episodes))
+	}
+	ep := ch.Episodes[0]
+	if ep.InfoHash != testHash || ep.URL() != testTorrent || ep.Size != 1234 || ep.PubDate.IsZero() || ep.Link != "https://example.com/show" {
+		t.Errorf("episode %+v", ep)
+	}
+	if ep.Release.Resolution != "1080p" || ep.Number.Season != 1 {
+		t.Errorf("episode release %+v number %+v", ep.Release, ep.Number)
+	}
+	// base32 info hashes are converted to hex and the magnet link is used
+	if ep := ch.Episodes[1]; ep.InfoHash != "0123456789abcdef0123456789abcdef01234567" || ep.URL() != testBase32 {
+		t.Errorf("magnet episode %+v", ep)
+	}
+}
+
+func TestParseAtom(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Atom</title>
+<entry>
+<title>Show S02E01 720p WEB x264-GROUP</title>
+<id>urn:uuid:1</id>
+<updated>2024-01-02T03:04:05Z</updated>
+<link href="https://example.com/entry"/>
+<link rel="enclosure" href="` + testMagnet + `"/>
+</entry>
+</feed>`
+	ch, err := ParseGenericFeed([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Title != "Atom" || len(ch.Episodes) != 1 {
+		t.Fatalf("channel %q with %d episodes", ch.Title, len(ch.Episodes))
+	}
+	ep := ch.Episodes[0]
+	if ep.InfoHash != testHash || ep.URL() != testMagnet || ep.Link != "https://example.com/entry" || ep.GUID != "urn:uuid:1" || ep.PubDate.IsZero() {
+		t.Errorf("episode %+v", ep)
+	}
+}
+
+func TestParseTorznab(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+<channel>
+<title>Indexer</title>
+<ttl>15</ttl>
+<item>
+<title>Show S03E04 2160p WEB-DL x265-GROUP</title>
+<guid>https://indexer.example/details/1</guid>
+<enclosure type="application/x-bittorrent" url="` + testTorrent + `" length="1"/>
+<torznab:attr name="infohash" value="0123456789ABCDEF0123456789ABCDEF01234567"/>
+<torznab:attr name="magneturl" value="` + testMagnet + `"/>
+<torznab:attr name="size" value="5678"/>
+</item>
+</channel>
+</rss>`
+	ch, err := ParseTorznab([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.TTL != 15 || len(ch.Episodes) != 1 {
+		t.Fatalf("ttl %d with %d episodes", ch.TTL, len(ch.Episodes))
+	}
+	ep := ch.Episodes[0]
+	if ep.InfoHash != testHash || ep.URL() != testMagnet || ep.Size != 5678 || ep.GUID != "https://indexer.example/details/1" {
+		t.Errorf("episode %+v", ep)
+	}
+}
+
+func TestParseTorznabError(t *testing.T) {
+	_, err := ParseTorznab([]byte(`<?xml version="1.0" encoding="UTF-8"?><error code="100" description="Incorrect user credentials"/>`))
+	var fe FeedError
+	if !errors.As(err, &fe) || ClassifyError(err) != ErrorClassSemantic {
+		t.Fatalf("got %v, want a semantic feed error", err)
+	}
+}
+
+func TestParseGenericFeedUnknownFormat(t *testing.T) {
+	_, err := ParseGenericFeed([]byte(`<html><body>not a feed</body></html>`))
+	if ClassifyError(err) != ErrorClassSemantic {
+		t.Fatalf("got %v (%s), want a semantic error", err, ClassifyError(err))
+	}
+}
@@ -0,0 +1,109 @@
+package showrss
+
+import (
+	"sync"
+	"time"
+)
+
+type FeedState string
+
+const (
+	FeedStatePending FeedState = "pending" // no fetch has completed yet
+	FeedStateHealthy FeedState = "healthy" // the last fetch succeeded
+	FeedStateFailing FeedState = "failing" // the last fetch failed
+	FeedStateFailed  FeedState = "failed"  // the feed was rejected or can not be used, it is retried at the longest interval
+)
+
+// FeedStatus is the state of a monitored feed source.
+type FeedStatus struct {
+	Source      string    `json:"source"`
+	State       FeedState `json:"state"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	NextRetry   time.Time `json:"next_retry"`
+	NextPoll    time.Time `json:"next_poll"` // scheduled poll after the last successful fetch
+
+	FailingSince time.Time `json:"failing_since,omitempty"` // first failed fetch after the last successful fetch
+}
+
+// fresh reports whether the feed was fetched successfully within its poll
+// interval, allowing grace for a late poll.
+func (s FeedStatus) fresh(now time.Time, grace time.Duration) bool {
+	return !s.LastSuccess.IsZero() && now.Before(s.NextPoll.Add(grace))
+}
+
+// FeedHealth tracks the status of a monitored feed source. A nil *FeedHealth
+// ignores all updates.
+type FeedHealth struct {
+	mu     sync.Mutex
+	status FeedStatus
+}
+
+func newFeedHealth(src FeedSource) *FeedHealth {
+	return &FeedHealth{
+		status: FeedStatus{
+			Source: src.String(),
+			State:  FeedStatePending,
+		},
+	}
+}
+
+// Status returns a copy of the current status.
+func (h *FeedHealth) Status() FeedStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+func (h *FeedHealth) success(next time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.status.State = FeedStateHealthy
+	h.status.Failures = 0
+	h.status.FailingSince = time.Time{}
+	h.status.LastError = ""
+	h.status.LastAttempt = now
+	h.status.LastSuccess = now
+	h.status.NextRetry = time.Time{}
+	h.status.NextPoll = now.Add(next)
+}
+
+func (h *FeedHealth) failure(err error, next time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if h.status.Failures == 0 {
+		h.status.FailingSince = now
+	}
+	h.status.State = FeedStateFailing
+	h.status.Failures++
+	h.status.LastError = err.Error()
+	h.status.LastAttempt = now
+	h.status.NextRetry = now.Add(next)
+}
+
+func (h *FeedHealth) rejected(err error, next time.Duration) {
+	if h == nil {
+		return
+	}
+	h.failure(err, next)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.State = FeedStateFailed
+}
+
+// SubscriptionStatus is the status of the feed sources of a subscription.
+type SubscriptionStatus struct {
+	ID      string       `json:"id"`
+	Paused  bool         `json:"paused"`
+	Sources []FeedStatus `json:"sources"`
+}
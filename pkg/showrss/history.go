@@ -0,0 +1,176 @@
+package showrss
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrEpisodeNotFound = errors.New("episode not found")
+
+// state returns the state of the item. Items stored before states were
+// recorded are added unless they have been replaced.
+func (e dbEpisode) state() EpisodeState {
+	switch {
+	case e.ReplacedBy != "":
+		return EpisodeStateReplaced
+	case e.State != "":
+		return e.State
+	}
+	return EpisodeStateAdded
+}
+
+// EpisodeFilter selects items of the episode history.
+type EpisodeFilter struct {
+	Show   string       // show name, case insensitive, or showrss show id
+	Since  time.Time    // created at or after, if not zero
+	Until  time.Time    // created before, if not zero
+	State  EpisodeState // if not empty
+	Offset int
+	Limit  int // zero means no limit
+}
+
+func (f EpisodeFilter) match(e dbEpisode) bool {
+	if f.Show != "" {
+		if !strings.EqualFold(f.Show, e.Episode.ShowName) && f.Show != strconv.Itoa(e.Episode.ShowID) {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.Created.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Created.Before(f.Until) {
+		return false
+	}
+	if f.State != "" && e.state() != f.State {
+		return false
+	}
+	return true
+}
+
+// listEpisodes returns the page of history items matching f, newest first,
+// and the total number of matching items.
+func (db *DB) listEpisodes(f EpisodeFilter) ([]dbEpisode, int, error) {
+	var items []dbEpisode
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAdded).ForEach(func(k, v []byte) error {
+			var dbep dbEpisode
+			if err := json.Unmarshal(v, &dbep); err != nil {
+				log.Warn().Err(err).Str("info_hash", string(k)).Msg("could not decode added item")
+				return nil
+			}
+			dbep.State = dbep.state()
+			if f.match(dbep) {
+				items = append(items, dbep)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Created.After(items[j].Created)
+	})
+	total := len(items)
+	if f.Offset >= total {
+		return []dbEpisode{}, total, nil
+	}
+	items = items[f.Offset:]
+	if f.Limit > 0 && len(items) > f.Limit {
+		items = items[:f.Limit]
+	}
+	return items, total, nil
+}
+
+func (db *DB) getEpisode(infoHash string) (dbEpisode, error) {
+	var dbep dbEpisode
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketAdded).Get([]byte(strings.ToLower(infoHash)))
+		if data == nil {
+			return ErrEpisodeNotFound
+		}
+		return json.Unmarshal(data, &dbep)
+	})
+	dbep.State = dbep.state()
+	return dbep, err
+}
+
+// hasEpisode reports whether the item with infoHash is in the history.
+func (db *DB) hasEpisode(infoHash string) (bool, error) {
+	var found bool
+	err := db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(bucketAdded).Get([]byte(strings.ToLower(infoHash))) != nil
+		return nil
+	})
+	return found, err
+}
+
+// historyItem returns the history item with infoHash, or an item holding
+// only the info hash if it is missing.
+func historyItem(tx *bolt.Tx, infoHash string) dbEpisode {
+	dbep := dbEpisode{Episode: Episode{InfoHash: infoHash}}
+	if data := tx.Bucket(bucketAdded).Get([]byte(strings.ToLower(infoHash))); data != nil {
+		if err := json.Unmarshal(data, &dbep); err != nil {
+			log.Warn().Err(err).Str("info_hash", infoHash).Msg("could not decode added item")
+		}
+	}
+	return dbep
+}
+
+// DeleteEpisode removes the item with infoHash from the history, see
+// deleteEpisode.
+func (d *ShowRSSDownloader) DeleteEpisode(infoHash string) error {
+	dbep, err := d.DB.getEpisode(infoHash)
+	if err != nil {
+		return err
+	}
+	if err := d.DB.deleteEpisode(infoHash); err != nil {
+		return err
+	}
+	e := episodeEvent(EventRemoved, dbep.Subscription, dbep.Episode)
+	e.Reason = "removed from history"
+	d.Events().Publish(e)
+	return nil
+}
+
+// deleteEpisode removes the item with infoHash from the history so that it
+// is added again the next time it shows up in a feed. The episode index
+// entry pointing to the item is removed as well. Unchanged feeds re-deliver
+// their last response, so the feed cache validators are kept.
+func (db *DB) deleteEpisode(infoHash string) error {
+	infoHash = strings.ToLower(infoHash)
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAdded)
+		data := bucket.Get([]byte(infoHash))
+		if data == nil {
+			return ErrEpisodeNotFound
+		}
+		var dbep dbEpisode
+		if err := json.Unmarshal(data, &dbep); err != nil {
+			return err
+		}
+		if err := bucket.Delete([]byte(infoHash)); err != nil {
+			return err
+		}
+		dbep.Episode.parseTitle()
+		if identity := dbep.Episode.Identity(); identity != "" {
+			entry, err := getEpisodeIndex(tx, identity)
+			if err != nil {
+				return err
+			}
+			if entry != nil && entry.InfoHash == infoHash {
+				if err := tx.Bucket(bucketEpisodes).Delete([]byte(identity)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package showrss
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserAgent   = "transmission-showrss"
+	defaultTimeout     = 15 * time.Second
+	defaultMaxBodySize = 10 << 20
+)
+
+// HTTPConfig configures the http client used to fetch feeds.
+type HTTPConfig struct {
+	UserAgent   string
+	Proxy       string // http, https or socks5 proxy url
+	Timeout     time.Duration
+	MaxBodySize int64
+}
+
+// NewClient creates a http client with a transport using the configured
+// proxy. The zero value uses the proxy from the environment.
+func (h HTTPConfig) NewClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if h.Proxy != "" {
+		proxyURL, err := url.Parse(h.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %v", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme '%s'", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// userAgentTransport sets the User-Agent header of requests without one.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// withUserAgent returns a copy of hc which sends userAgent, or the default
+// user agent if it is empty.
+func withUserAgent(hc *http.Client, userAgent string) *http.Client {
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	c := *hc
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.Transport = userAgentTransport{next: next, userAgent: userAgent}
+	return &c
+}
+
+// ClientOpts returns the client options for the configuration.
+func (h HTTPConfig) ClientOpts() ([]clientOpt, error) {
+	hc, err := h.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	opts := []clientOpt{ClientHTTPClient(hc)}
+	if h.UserAgent != "" {
+		opts = append(opts, ClientUserAgent(h.UserAgent))
+	}
+	if h.Timeout > 0 {
+		opts = append(opts, ClientTimeout(h.Timeout))
+	}
+	if h.MaxBodySize > 0 {
+		opts = append(opts, ClientMaxBodySize(h.MaxBodySize))
+	}
+	return opts, nil
+}
+
+// HTTPError is returned when a feed request gets a non 2xx response.
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // zero if the response had no Retry-After header
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: unexpected response status %s", redactURL(e.URL), e.Status)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func newHTTPError(url string, resp *http.Response) *HTTPError {
+	e := &HTTPError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return e
+}
+
+// parseRetryAfter parses a Retry-After header value which is either a number
+// of seconds or a http date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retryAfter returns the server requested retry delay of err, if any.
+func retryAfter(err error) time.Duration {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.RetryAfter
+	}
+	return 0
+}
+
+// ErrBodyTooLarge is returned when a feed response exceeds the body size limit.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// readBody reads a possibly gzip encoded response body, at most max bytes
+// after decompression.
+func readBody(resp *http.Response, max int64) ([]byte, error) {
+	var r io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrBodyTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,306 @@
+package showrss
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Metrics are exposed in the prometheus text format.
+var (
+	metricFeedFetches = newCounterVec("showrss_feed_fetches_total",
+		"Feed fetches by result (ok, not_modified, error).", "source", "result")
+	metricFeedFetchDuration = newHistogramVec("showrss_feed_fetch_duration_seconds",
+		"Duration of feed fetches.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "source")
+	metricFeedFailures = newCounterVec("showrss_feed_failures_total",
+		"Failed feed fetches by error class (transient, malformed, semantic).", "source", "class")
+	metricFeedConsecutiveFailures = newGaugeVec("showrss_feed_consecutive_failures",
+		"Failed feed fetches since the last successful fetch.", "source")
+	metricFeedBackoff = newGaugeVec("showrss_feed_backoff_seconds",
+		"Current retry delay of a failing feed, zero if the feed is healthy.", "source")
+	metricFeedLastSuccess = newGaugeVec("showrss_feed_last_success_timestamp_seconds",
+		"Time of the last successful feed fetch.", "source")
+	metricEpisodeEvents = newCounterVec("showrss_episode_events_total",
+		"Episode lifecycle events by type.", "type")
+	metricRPCDuration = newHistogramVec("showrss_transmission_rpc_duration_seconds",
+		"Duration of transmission rpc calls.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "method")
+	metricRPCErrors = newCounterVec("showrss_transmission_rpc_errors_total",
+		"Failed transmission rpc calls.", "method")
+	metricEventsDropped = newCounterVec("showrss_events_dropped_total",
+		"Events dropped for subscribers which did not keep up.", "subscriber")
+	metricNotifications = newCounterVec("showrss_notifications_total",
+		"Notifications sent by notifier type and result (ok, error).", "type", "result")
+	metricAlertsFiring = newGaugeVec("showrss_alerts_firing",
+		"Firing alerts by rule.", "rule")
+	metricDBSize = newGaugeVec("showrss_db_size_bytes",
+		"Size of the bolt database.")
+
+	allMetrics = []metricWriter{
+		metricFeedFetches,
+		metricFeedFetchDuration,
+		metricFeedFailures,
+		metricFeedConsecutiveFailures,
+		metricFeedBackoff,
+		metricFeedLastSuccess,
+		metricEpisodeEvents,
+		metricRPCDuration,
+		metricRPCErrors,
+		metricEventsDropped,
+		metricNotifications,
+		metricAlertsFiring,
+		metricDBSize,
+	}
+)
+
+// observeRPC records the duration and result of a transmission rpc call
+// started at start.
+func observeRPC(method string, start time.Time, err error) {
+	metricRPCDuration.observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		metricRPCErrors.add(1, method)
+	}
+}
+
+// countEpisodeEvent is the event handler counting episode lifecycle events.
+func countEpisodeEvent(e Event) {
+	metricEpisodeEvents.add(1, string(e.Type))
+}
+
+// MetricsHandler serves all metrics in the prometheus text format.
+func MetricsHandler(db *DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if db != nil {
+			err := db.View(func(tx *bolt.Tx) error {
+				metricDBSize.set(float64(tx.Size()))
+				return nil
+			})
+			if err != nil {
+				log.Err(err).Msg("could not read db size")
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, m := range allMetrics {
+			m.writeTo(bw)
+		}
+		if err := bw.Flush(); err != nil {
+			log.Err(err).Msg("error writing metrics")
+		}
+	}
+}
+
+type metricWriter interface {
+	writeTo(w io.Writer)
+}
+
+// metricVec is a metric family with one series per set of label values.
+type metricVec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64 // histograms only, not cumulative
+	count       uint64   // histograms only
+}
+
+func newMetricVec(name, help, typ string, labels []string) metricVec {
+	return metricVec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+}
+
+// get returns the series for labelValues, the caller must hold m.mu.
+func (m *metricVec) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", m.name, len(labelValues), len(m.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		m.series[key] = s
+	}
+	return s
+}
+
+// delete removes the series for labelValues.
+func (m *metricVec) delete(labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.series, strings.Join(labelValues, "\xff"))
+}
+
+// sorted returns the series ordered by label values, the caller must hold
+// m.mu.
+func (m *metricVec) sorted() []*metricSeries {
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]*metricSeries, len(keys))
+	for i, k := range keys {
+		series[i] = m.series[k]
+	}
+	return series
+}
+
+func (m *metricVec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, helpReplacer.Replace(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+}
+
+// formatLabels formats label pairs as {a="1",b="2"}.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueReplacer.Replace(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type counterVec struct{ metricVec }
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{newMetricVec(name, help, "counter", labels)}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labelValues).value += v
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+type gaugeVec struct{ metricVec }
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{newMetricVec(name, help, "gauge", labels)}
+}
+
+func (g *gaugeVec) set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labelValues).value = v
+}
+
+func (g *gaugeVec) add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labelValues).value += v
+}
+
+func (g *gaugeVec) writeTo(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, s := range g.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+type histogramVec struct {
+	metricVec
+	bounds []float64 // bucket upper bounds, ascending
+}
+
+func newHistogramVec(name, help string, bounds []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		metricVec: newMetricVec(name, help, "histogram", labels),
+		bounds:    bounds,
+	}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if v <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	names := append(append([]string{}, h.labels...), "le")
+	for _, s := range h.sorted() {
+		values := append(append([]string{}, s.labelValues...), "")
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += s.buckets[i]
+			values[len(values)-1] = formatValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), cumulative)
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), s.count)
+		labels := formatLabels(h.labels, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
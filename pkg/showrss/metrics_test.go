@@ -0,0 +1,105 @@
+package showrss
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterExposition(t *testing.T) {
+	c := newCounterVec("test_total", "Help with \\ and\nnewline.", "source", "result")
+	c.add(1, `feed "a"`, "ok")
+	c.add(2, "b\\c\nd", "error")
+	c.add(1, `feed "a"`, "ok")
+	var buf bytes.Buffer
+	c.writeTo(&buf)
+	want := `# HELP test_total Help with \\ and\nnewline.
+# TYPE test_total counter
+test_total{source="b\\c\nd",result="error"} 2
+test_total{source="feed \"a\"",result="ok"} 2
+`
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestGaugeExposition(t *testing.T) {
+	g := newGaugeVec("test_gauge", "A gauge.", "rule")
+	g.set(3, "a")
+	g.add(-1, "a")
+	g.set(math.Inf(1), "b")
+	g.set(0.5, "c")
+	g.delete("c")
+	var buf bytes.Buffer
+	g.writeTo(&buf)
+	want := `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge{rule="a"} 2
+test_gauge{rule="b"} +Inf
+`
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	unlabeled := newGaugeVec("test_size", "Size.")
+	unlabeled.set(1024)
+	buf.Reset()
+	unlabeled.writeTo(&buf)
+	if !strings.HasSuffix(buf.String(), "\ntest_size 1024\n") {
+		t.Fatalf("got\n%s", buf.String())
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Durations.", []float64{0.1, 1, 2.5}, "method")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2, 10} {
+		h.observe(v, "get")
+	}
+	var buf bytes.Buffer
+	h.writeTo(&buf)
+	want := `# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{method="get",le="0.1"} 2
+test_seconds_bucket{method="get",le="1"} 3
+test_seconds_bucket{method="get",le="2.5"} 4
+test_seconds_bucket{method="get",le="+Inf"} 5
+test_seconds_sum{method="get"} 12.65
+test_seconds_count{method="get"} 5
+`
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestMetricLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("wrong number of label values did not panic")
+		}
+	}()
+	newCounterVec("test_total", "Help.", "a", "b").add(1, "only one")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	MetricsHandler(nil)(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %s", ct)
+	}
+	for _, m := range allMetrics {
+		var name string
+		switch m := m.(type) {
+		case *counterVec:
+			name = m.name
+		case *gaugeVec:
+			name = m.name
+		case *histogramVec:
+			name = m.name
+		}
+		if !strings.Contains(rec.Body.String(), "# TYPE "+name+" ") {
+			t.Errorf("metric %s missing", name)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package showrss
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+// NotifierType is the kind of a notification target.
+type NotifierType string
+
+const (
+	NotifierWebhook NotifierType = "webhook" // POST of a json body to URL
+	NotifierNtfy    NotifierType = "ntfy"    // ntfy topic URL, e.g. https://ntfy.sh/mytopic
+	NotifierGotify  NotifierType = "gotify"  // gotify server URL
+	NotifierDiscord NotifierType = "discord" // discord webhook URL
+)
+
+// defaultNotifyEvents are the events notified if a notifier has no event
+// filter.
+var defaultNotifyEvents = []EventType{EventAdded, EventCompleted, EventAlertFiring, EventAlertResolved}
+
+// NotifierConfig is a notification target.
+type NotifierConfig struct {
+	Type     NotifierType `json:"type"`
+	URL      string       `json:"url"`
+	Token    string       `json:"token,omitempty"`    // gotify application token, also read from the token url parameter, or ntfy access token
+	Template string       `json:"template,omitempty"` // webhook body, a text/template executed with the Notification
+	Events   []EventType  `json:"events,omitempty"`   // defaults to episode_added, episode_completed, alert_firing and alert_resolved
+}
+
+func (c NotifierConfig) String() string {
+	return fmt.Sprintf("%s/%s", c.Type, c.redacted().URL)
+}
+
+// redacted returns c without its secrets, the token and the credentials in
+// the url. The token of a discord webhook is the last segment of the url
+// path.
+func (c NotifierConfig) redacted() NotifierConfig {
+	if c.Token != "" {
+		c.Token = "redacted"
+	}
+	c.URL = redactURL(c.URL)
+	if c.Type == NotifierDiscord {
+		if u, err := url.Parse(c.URL); err == nil && strings.Count(u.Path, "/") > 1 {
+			u.Path = path.Join(path.Dir(u.Path), "redacted")
+			c.URL = u.String()
+		}
+	}
+	return c
+}
+
+// wants reports whether the notifier is interested in events of typ.
+func (c NotifierConfig) wants(typ EventType) bool {
+	events := c.Events
+	if len(events) == 0 {
+		events = defaultNotifyEvents
+	}
+	for _, t := range events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyConfig configures the notifiers receiving the events of all
+// subscriptions. Subscriptions can add their own notifiers.
+type NotifyConfig struct {
+	Notifiers []NotifierConfig
+	Timeout   time.Duration
+}
+
+// Notification is the payload sent to notifiers.
+type Notification struct {
+	Event        EventType `json:"event"`
+	Time         time.Time `json:"time"`
+	Subscription string    `json:"subscription,omitempty"`
+	ShowName     string    `json:"show_name"`
+	Title        string    `json:"title"`
+	InfoHash     string    `json:"info_hash"`
+	DownloadDir  string    `json:"download_dir,omitempty"`
+	Alert        string    `json:"alert,omitempty"` // alert rule of alert notifications
+	Reason       string    `json:"reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Episode      Episode   `json:"episode"`
+}
+
+func newNotification(e Event) Notification {
+	n := Notification{
+		Event:        e.Type,
+		Time:         e.Time,
+		Subscription: e.Subscription,
+		DownloadDir:  e.DownloadDir,
+		Alert:        e.Alert,
+		Reason:       e.Reason,
+		Error:        e.Error,
+	}
+	if e.Episode != nil {
+		n.Episode = *e.Episode
+		n.ShowName = e.Episode.ShowName
+		n.Title = e.Episode.Title
+		n.InfoHash = e.Episode.InfoHash
+	}
+	return n
+}
+
+var notificationVerbs = map[EventType]string{
+	EventDiscovered: "Discovered",
+	EventFiltered:   "Skipped",
+	EventQueued:     "Queued",
+	EventAdded:      "Added",
+	EventFailed:     "Failed",
+	EventCompleted:  "Completed",
+	EventRemoved:    "Removed",
+
+	EventAlertFiring:   "Alert",
+	EventAlertResolved: "Resolved",
+}
+
+// Subject returns a one line summary of the notification.
+func (n Notification) Subject() string {
+	verb, ok := notificationVerbs[n.Event]
+	if !ok {
+		verb = string(n.Event)
+	}
+	if n.Alert != "" {
+		return fmt.Sprintf("%s: %s", verb, n.Reason)
+	}
+	return fmt.Sprintf("%s: %s", verb, n.Title)
+}
+
+// Message returns a human readable description of the notification.
+func (n Notification) Message() string {
+	var sb strings.Builder
+	sb.WriteString(n.Subject())
+	if n.ShowName != "" {
+		fmt.Fprintf(&sb, "\nShow: %s", n.ShowName)
+	}
+	if n.DownloadDir != "" {
+		fmt.Fprintf(&sb, "\nDirectory: %s", n.DownloadDir)
+	}
+	if n.Reason != "" && n.Alert == "" {
+		fmt.Fprintf(&sb, "\nReason: %s", n.Reason)
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&sb, "\nError: %s", n.Error)
+	}
+	if n.InfoHash != "" {
+		fmt.Fprintf(&sb, "\nInfo hash: %s", n.InfoHash)
+	}
+	return sb.String()
+}
+
+// Notifier sends notifications to a notification target.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NewNotifier returns the notifier for c.
+func NewNotifier(c NotifierConfig, hc *http.Client) (Notifier, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("notifier %s: url is required", c.Type)
+	}
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	for _, t := range c.Events {
+		if _, ok := notificationVerbs[t]; !ok {
+			return nil, fmt.Errorf("notifier %s: unknown event type '%s'", c.Type, t)
+		}
+	}
+	switch c.Type {
+	case NotifierWebhook:
+		w := &Webhook{HTTPClient: hc, URL: c.URL}
+		if c.Template != "" {
+			tmpl, err := template.New("webhook").Funcs(notifyFuncs).Parse(c.Template)
+			if err != nil {
+				return nil, fmt.Errorf("notifier %s: invalid template: %v", c.Type, err)
+			}
+			w.Template = tmpl
+		}
+		return w, nil
+	case NotifierNtfy:
+		return &Ntfy{HTTPClient: hc, URL: c.URL, Token: c.Token}, nil
+	case NotifierGotify:
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %v", c.Type, err)
+		}
+		token := c.Token
+		if token == "" {
+			token = u.Query().Get("token")
+		}
+		if token == "" {
+			return nil, fmt.Errorf("notifier %s: token is required", c.Type)
+		}
+		u.RawQuery = ""
+		return &Gotify{HTTPClient: hc, URL: u.String(), Token: token}, nil
+	case NotifierDiscord:
+		return &Discord{HTTPClient: hc, URL: c.URL}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type '%s'", c.Type)
+}
+
+// notifyFuncs are the functions available in webhook templates.
+var notifyFuncs = template.FuncMap{
+	// json encodes a value, for embedding strings in json templates:
+	// {"text": {{json .Title}}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Webhook posts notifications as json. The body is the Notification unless
+// a template is set.
+type Webhook struct {
+	HTTPClient *http.Client
+	URL        string
+	Template   *template.Template
+}
+
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	var body bytes.Buffer
+	if w.Template != nil {
+		if err := w.Template.Execute(&body, n); err != nil {
+			return fmt.Errorf("error executing webhook template: %v", err)
+		}
+	} else if err := json.NewEncoder(&body).Encode(n); err != nil {
+		return err
+	}
+	return postNotification(ctx, w.HTTPClient, w.URL, "application/json", &body, nil)
+}
+
+// Ntfy publishes notifications to a ntfy topic.
+type Ntfy struct {
+	HTTPClient *http.Client
+	URL        string // topic url
+	Token      string // access token, optional
+}
+
+func (t *Ntfy) Notify(ctx context.Context, n Notification) error {
+	header := http.Header{}
+	header.Set("Title", n.Subject())
+	header.Set("Tags", "tv")
+	if n.Event == EventFailed || n.Event == EventAlertFiring {
+		header.Set("Priority", "high")
+	}
+	if t.Token != "" {
+		header.Set("Authorization", "Bearer "+t.Token)
+	}
+	return postNotification(ctx, t.HTTPClient, t.URL, "text/plain; charset=utf-8", strings.NewReader(n.Message()), header)
+}
+
+// Gotify sends notifications to a gotify server.
+type Gotify struct {
+	HTTPClient *http.Client
+	URL        string // server url
+	Token      string // application token
+}
+
+func (g *Gotify) Notify(ctx context.Context, n Notification) error {
+	priority := 5
+	if n.Event == EventFailed || n.Event == EventAlertFiring {
+		priority = 8
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"title":    n.Subject(),
+		"message":  n.Message(),
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Gotify-Key", g.Token)
+	return postNotification(ctx, g.HTTPClient, strings.TrimSuffix(g.URL, "/")+"/message", "application/json", bytes.NewReader(data), header)
+}
+
+// Discord posts notifications to a discord webhook.
+type Discord struct {
+	HTTPClient *http.Client
+	URL        string // webhook url
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordEmbed struct {
+	Title     string         `json:"title"`
+	Fields    []discordField `json:"fields,omitempty"`
+	Timestamp string         `json:"timestamp,omitempty"`
+}
+
+func (d *Discord) Notify(ctx context.Context, n Notification) error {
+	embed := discordEmbed{Title: n.Subject()}
+	if !n.Time.IsZero() {
+		embed.Timestamp = n.Time.UTC().Format(time.RFC3339)
+	}
+	add := func(name, value string, inline bool) {
+		if value != "" {
+			embed.Fields = append(embed.Fields, discordField{Name: name, Value: value, Inline: inline})
+		}
+	}
+	add("Show", n.ShowName, true)
+	add("Directory", n.DownloadDir, true)
+	add("Reason", n.Reason, false)
+	add("Error", n.Error, false)
+	add("Info hash", n.InfoHash, false)
+	data, err := json.Marshal(map[string]interface{}{
+		"username": "transmission-showrss",
+		"embeds":   []discordEmbed{embed},
+	})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, d.HTTPClient, d.URL, "application/json", bytes.NewReader(data), nil)
+}
+
+// postNotification posts body to url and fails on non 2xx responses.
+func postNotification(ctx context.Context, hc *http.Client, target, contentType string, body io.Reader, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: unexpected response status %s: %s", redactURL(target), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
+
+// configuredNotifier is a notifier built from its configuration.
+type configuredNotifier struct {
+	Notifier
+	config NotifierConfig
+}
+
+// newNotifiers builds the notifiers of configs.
+func newNotifiers(configs []NotifierConfig, hc *http.Client) ([]configuredNotifier, error) {
+	var notifiers []configuredNotifier
+	for _, c := range configs {
+		notifier, err := NewNotifier(c, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, configuredNotifier{Notifier: notifier, config: c})
+	}
+	return notifiers, nil
+}
+
+// subscriptionNotifiers returns the notifiers of the subscription with id.
+// They are built on first use and kept until resetSubscriptionNotifiers is
+// called for the subscription.
+func (d *ShowRSSDownloader) subscriptionNotifiers(id string) []configuredNotifier {
+	d.mu.Lock()
+	notifiers, ok := d.subNotifiers[id]
+	gen := d.subNotifiersGen
+	d.mu.Unlock()
+	if ok {
+		return notifiers
+	}
+	sub, err := d.DB.getSubscription(id)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		log.Err(err).Str("subscription", id).Msg("could not read subscription notifiers")
+		return nil
+	}
+	for _, c := range sub.Notifiers {
+		notifier, err := NewNotifier(c, d.notifyClient)
+		if err != nil {
+			log.Err(err).Str("subscription", id).Str("notifier", c.String()).Msg("invalid notifier")
+			continue
+		}
+		notifiers = append(notifiers, configuredNotifier{Notifier: notifier, config: c})
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if gen == d.subNotifiersGen {
+		if d.subNotifiers == nil {
+			d.subNotifiers = make(map[string][]configuredNotifier)
+		}
+		d.subNotifiers[id] = notifiers
+	}
+	return notifiers
+}
+
+// resetSubscriptionNotifiers makes the notifiers of the subscription with id
+// be built again on their next use.
+func (d *ShowRSSDownloader) resetSubscriptionNotifiers(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.subNotifiers, id)
+	d.subNotifiersGen++
+}
+
+const defaultNotifyTimeout = 10 * time.Second
+
+// notify is the event handler sending events to the global notifiers and to
+// the notifiers of the subscription of the event.
+func (d *ShowRSSDownloader) notify(e Event) {
+	targets := d.notifiers
+	if e.Subscription != "" {
+		targets = append(append([]configuredNotifier{}, targets...), d.subscriptionNotifiers(e.Subscription)...)
+	}
+	n := newNotification(e)
+	timeout := d.Notify.Timeout
+	if timeout <= 0 {
+		timeout = defaultNotifyTimeout
+	}
+	for _, t := range targets {
+		if !t.config.wants(e.Type) {
+			continue
+		}
+		logger := log.With().Str("notifier", t.config.String()).Str("event", string(e.Type)).Logger()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := t.Notify(ctx, n)
+		cancel()
+		if err != nil {
+			metricNotifications.add(1, string(t.config.Type), "error")
+			logger.Err(err).Msg("notification failed")
+			continue
+		}
+		metricNotifications.add(1, string(t.config.Type), "ok")
+		logger.Debug().Msg("notification sent")
+	}
+}
+
+// SetSubscriptionNotifiers replaces the notifiers of the subscription with
+// id.
+func (d *ShowRSSDownloader) SetSubscriptionNotifiers(id string, notifiers []NotifierConfig) (Subscription, error) {
+	for _, c := range notifiers {
+		if _, err := NewNotifier(c, nil); err != nil {
+			return Subscription{}, err
+		}
+	}
+	sub, err := d.DB.getSubscription(id)
+	if err != nil {
+		return sub, err
+	}
+	sub.Notifiers = notifiers
+	sub.Updated = time.Now()
+	if err := d.DB.putSubscription(sub); err != nil {
+		return sub, err
+	}
+	d.resetSubscriptionNotifiers(id)
+	return sub, nil
+}
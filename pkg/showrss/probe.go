@@ -0,0 +1,90 @@
+package showrss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pborzenkov/go-transmission/transmission"
+	bolt "go.etcd.io/bbolt"
+)
+
+// readyGrace is how long after its scheduled poll a feed still counts as
+// fresh, so that a slow or rate limited poll does not flap readiness.
+const readyGrace = 5 * time.Minute
+
+// ProbeResult is the result of a health or readiness check.
+type ProbeResult struct {
+	OK     bool              `json:"ok"`
+	Checks map[string]string `json:"checks"` // check name to "ok" or the error
+}
+
+func (r *ProbeResult) check(name string, err error) {
+	if err != nil {
+		r.OK = false
+		r.Checks[name] = err.Error()
+		return
+	}
+	r.Checks[name] = "ok"
+}
+
+func newProbeResult() ProbeResult {
+	return ProbeResult{OK: true, Checks: make(map[string]string)}
+}
+
+// Healthy checks that the process is able to work: the db is open.
+func (d *ShowRSSDownloader) Healthy() ProbeResult {
+	r := newProbeResult()
+	r.check("db", d.checkDB())
+	return r
+}
+
+// Ready checks that the downloader is doing its job: the db is open,
+// transmission is reachable and at least one feed has been fetched
+// successfully within its poll interval.
+func (d *ShowRSSDownloader) Ready(ctx context.Context) ProbeResult {
+	r := newProbeResult()
+	r.check("db", d.checkDB())
+	r.check("transmission", d.checkTransmission(ctx))
+	r.check("feeds", d.checkFeeds(time.Now()))
+	return r
+}
+
+func (d *ShowRSSDownloader) checkDB() error {
+	if d.DB == nil {
+		return errors.New("no db")
+	}
+	return d.DB.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (d *ShowRSSDownloader) checkTransmission(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := d.TC.GetSession(ctx, transmission.SessionFieldVersion)
+	observeRPC("session-get", start, err)
+	return err
+}
+
+func (d *ShowRSSDownloader) checkFeeds(now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.monitors == nil {
+		return errNotStarted
+	}
+	var sources int
+	for _, rm := range d.monitors {
+		for _, h := range rm.health {
+			sources++
+			if h.Status().fresh(now, readyGrace) {
+				return nil
+			}
+		}
+	}
+	if sources == 0 {
+		// nothing to fetch
+		return nil
+	}
+	return fmt.Errorf("none of %d feed sources fetched successfully within its poll interval", sources)
+}
@@ -0,0 +1,74 @@
+package showrss
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pborzenkov/go-transmission/transmission"
+	bolt "go.etcd.io/bbolt"
+)
+
+// episodeIndexEntry is the release added for an episode identity. Rank is
+// only set when ranking is enabled, entries indexed before the release was
+// stored only have the rank.
+type episodeIndexEntry struct {
+	InfoHash string    `json:"info_hash"`
+	Title    string    `json:"title"`
+	Rank     int       `json:"rank"`
+	Release  Release   `json:"release"`
+	Updated  time.Time `json:"updated"`
+}
+
+func getEpisodeIndex(tx *bolt.Tx, identity string) (*episodeIndexEntry, error) {
+	data := tx.Bucket(bucketEpisodes).Get([]byte(identity))
+	if data == nil {
+		return nil, nil
+	}
+	var entry episodeIndexEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func putEpisodeIndex(tx *bolt.Tx, identity string, entry episodeIndexEntry) error {
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketEpisodes).Put([]byte(identity), data)
+}
+
+// compareEntry compares the release of item with the release in entry like
+// RankingProfile.Compare. Entries without a release are compared by their
+// stored rank.
+func (d *ShowRSSDownloader) compareEntry(item Episode, entry *episodeIndexEntry) int {
+	if entry.Release == (Release{}) && entry.Rank != 0 {
+		return d.Ranking.Profile.Rank(item.Release) - entry.Rank
+	}
+	return d.Ranking.Profile.Compare(item.Release, entry.Release)
+}
+
+// indexRelease records item as the release added for its episode.
+func (d *ShowRSSDownloader) indexRelease(tx *bolt.Tx, item Episode) error {
+	identity := item.Identity()
+	if identity == "" {
+		return nil
+	}
+	entry := newEpisodeIndexEntry(item)
+	if d.Ranking.Enabled {
+		entry.Rank = d.Ranking.Profile.Rank(item.Release)
+	}
+	return putEpisodeIndex(tx, identity, entry)
+}
+
+// removeTorrent removes the torrent with infoHash from transmission.
+func (d *ShowRSSDownloader) removeTorrent(ctx context.Context, infoHash string, deleteData bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := d.TC.RemoveTorrents(ctx, transmission.IDs(transmission.Hash(infoHash)), deleteData)
+	observeRPC("torrent-remove", start, err)
+	return err
+}
@@ -0,0 +1,266 @@
+package showrss
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+// RecordConfig configures recording of feed responses and replaying them
+// instead of fetching feeds from the network.
+type RecordConfig struct {
+	RecordDir       string
+	RecordRetention time.Duration
+	ReplayDir       string
+	ReplayTTL       time.Duration // poll interval while replaying, zero uses the feed ttl
+}
+
+// SchedulerOpts returns the scheduler options for the configuration.
+func (r RecordConfig) SchedulerOpts() []schedulerOpt {
+	if r.ReplayDir != "" && r.ReplayTTL > 0 {
+		return []schedulerOpt{SchedulerTTL(r.ReplayTTL)}
+	}
+	return nil
+}
+
+// ClientOpts returns the client options for the configuration.
+func (r RecordConfig) ClientOpts() []clientOpt {
+	var opts []clientOpt
+	if r.RecordDir != "" {
+		opts = append(opts, ClientRecord(r.RecordDir, r.RecordRetention))
+	}
+	if r.ReplayDir != "" {
+		opts = append(opts, ClientReplay(r.ReplayDir))
+	}
+	return opts
+}
+
+// ClientRecord makes the client write every raw feed response to dir.
+// Recordings older than retention are removed, a zero retention keeps them
+// forever.
+func ClientRecord(dir string, retention time.Duration) clientOpt {
+	return func(c *Client) error {
+		c.recordDir = dir
+		c.recordRetention = retention
+		return nil
+	}
+}
+
+// ClientReplay makes the client serve the recordings in dir instead of
+// fetching feeds from the network. Each fetch of a url returns the next
+// recording of that url in time order. When the recordings of a url are
+// exhausted conditional fetches return ErrNotModified and unconditional
+// fetches return the last recording.
+func ClientReplay(dir string) clientOpt {
+	return func(c *Client) error {
+		c.replayDir = dir
+		return nil
+	}
+}
+
+// recording is a recorded feed response.
+type recording struct {
+	URL        string      `json:"url"`
+	Time       time.Time   `json:"time"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+func (r recording) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// recordingDir returns the directory holding the recordings of url.
+func recordingDir(dir, url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8]))
+}
+
+// recordTransport is a http.RoundTripper which writes all responses to a
+// directory. Gzip encoded responses are recorded and returned decoded.
+type recordTransport struct {
+	next      http.RoundTripper
+	dir       string
+	retention time.Duration
+	maxSize   int64
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := t.readBody(resp)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	rec := recording{
+		URL:        req.URL.String(),
+		Time:       time.Now(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+	}
+	if err := t.write(rec); err != nil {
+		log.Warn().Err(err).Str("feed_url", redactURL(rec.URL)).Msg("could not record feed response")
+	}
+	return resp, nil
+}
+
+// readBody reads the body of resp, decoding gzip bodies, at most one byte
+// more than the body size limit. The limit applies to the decoded body, a
+// recording of an oversized body fails the size check of the client with
+// ErrBodyTooLarge also when it is replayed.
+func (t *recordTransport) readBody(resp *http.Response) ([]byte, error) {
+	var r io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+	}
+	return io.ReadAll(io.LimitReader(r, t.maxSize+1))
+}
+
+func (t *recordTransport) write(rec recording) error {
+	dir := recordingDir(t.dir, rec.URL)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%020d.json", rec.Time.UnixNano()))
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		return err
+	}
+	if t.retention > 0 {
+		t.prune(dir, rec.Time.Add(-t.retention))
+	}
+	return nil
+}
+
+// prune removes the recordings in dir older than before.
+func (t *recordTransport) prune(dir string, before time.Time) {
+	names, err := recordingNames(dir)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not list recordings")
+		return
+	}
+	for _, name := range names {
+		var nanos int64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, ".json"), "%d", &nanos); err != nil {
+			continue
+		}
+		if time.Unix(0, nanos).Before(before) {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				log.Warn().Err(err).Msg("could not remove recording")
+			}
+		}
+	}
+}
+
+// recordingNames returns the recording file names in dir in time order.
+func recordingNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// replayTransport is a http.RoundTripper which serves recorded responses.
+type replayTransport struct {
+	dir string
+
+	mu   sync.Mutex
+	next map[string]int // index of the next recording by url
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	dir := recordingDir(t.dir, url)
+	names, err := recordingNames(dir)
+	if err != nil || len(names) == 0 {
+		return nil, fmt.Errorf("no recordings for %s", redactURL(url))
+	}
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+
+	t.mu.Lock()
+	if t.next == nil {
+		t.next = make(map[string]int)
+	}
+	idx := t.next[url]
+	if idx < len(names) {
+		t.next[url] = idx + 1
+	}
+	t.mu.Unlock()
+
+	if idx >= len(names) {
+		if conditional {
+			return &http.Response{
+				Status:     "304 Not Modified",
+				StatusCode: http.StatusNotModified,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+				Request:    req,
+			}, nil
+		}
+		idx = len(names) - 1
+	}
+	data, err := os.ReadFile(filepath.Join(dir, names[idx]))
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("could not decode recording %s: %v", names[idx], err)
+	}
+	log.Debug().
+		Str("feed_url", redactURL(url)).
+		Time("recorded", rec.Time).
+		Msg("replaying feed response")
+	return rec.response(req), nil
+}
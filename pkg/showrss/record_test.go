@@ -0,0 +1,75 @@
+package showrss
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRecordReplayGzip(t *testing.T) {
+	srv := gzipServer(t, testFeed)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	ch, err := NewClient(ClientRecord(dir, 0)).fetch(ctx, srv.URL, ParseRSS, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ch.Episodes) != 1 {
+		t.Fatalf("recorded fetch: %d episodes", len(ch.Episodes))
+	}
+	ch, err = NewClient(ClientReplay(dir)).fetch(ctx, srv.URL, ParseRSS, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ch.Episodes) != 1 {
+		t.Fatalf("replayed fetch: %d episodes", len(ch.Episodes))
+	}
+}
+
+func TestRecordBodyTooLarge(t *testing.T) {
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	bodies := map[string]string{
+		"compressed below the limit": testFeed + strings.Repeat(" ", 4096),
+		"compressed above the limit": string(noise),
+	}
+	for name, body := range bodies {
+		srv := gzipServer(t, body)
+		dir := t.TempDir()
+		ctx := context.Background()
+
+		_, err := NewClient(ClientRecord(dir, 0), ClientMaxBodySize(1024)).fetch(ctx, srv.URL, ParseRSS, false)
+		if !errors.Is(err, ErrBodyTooLarge) {
+			t.Fatalf("%s: recorded fetch: got %v, want %v", name, err, ErrBodyTooLarge)
+		}
+		_, err = NewClient(ClientReplay(dir), ClientMaxBodySize(1024)).fetch(ctx, srv.URL, ParseRSS, false)
+		if !errors.Is(err, ErrBodyTooLarge) {
+			t.Fatalf("%s: replayed fetch: got %v, want %v", name, err, ErrBodyTooLarge)
+		}
+	}
+}
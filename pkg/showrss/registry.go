@@ -0,0 +1,167 @@
+package showrss
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+var (
+	errNotStarted = errors.New("downloader not started")
+
+	ErrSubscriptionExists = errors.New("subscription already exists")
+	ErrSubscriptionPaused = errors.New("subscription is paused")
+)
+
+// runningMonitor is the set of scheduled sources of an active subscription.
+type runningMonitor struct {
+	ids    []string // scheduler entry ids
+	health []*FeedHealth
+}
+
+// startMonitor schedules polling of the sources of sub. The initial fetch of
+// each source is done by the scheduler so that a failing feed is retried in
+// the background instead of preventing startup.
+func (d *ShowRSSDownloader) startMonitor(sub Subscription) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.monitors == nil {
+		return errNotStarted
+	}
+	if _, ok := d.monitors[sub.ID]; ok {
+		return nil
+	}
+	sources, err := d.client.Sources(sub.Feed)
+	if err != nil {
+		return err
+	}
+
+	rm := &runningMonitor{}
+	d.monitors[sub.ID] = rm
+	for index, src := range sources {
+		log.Info().
+			Str("feed", sub.Feed.String()).
+			Str("source", src.String()).
+			Msg("adding monitor for feed")
+
+		id := fmt.Sprintf("%s/%d", sub.ID, index)
+		health := newFeedHealth(src)
+		rm.ids = append(rm.ids, id)
+		rm.health = append(rm.health, health)
+		d.scheduler.Add(id, src, health, d.deliverFunc(sub, index))
+	}
+	return nil
+}
+
+// stopMonitor stops polling the sources of the subscription with id, if
+// running.
+func (d *ShowRSSDownloader) stopMonitor(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if rm, ok := d.monitors[id]; ok {
+		for _, entryID := range rm.ids {
+			d.scheduler.Remove(entryID)
+		}
+		delete(d.monitors, id)
+		log.Info().Str("subscription", id).Msg("stopped monitor for feed")
+	}
+}
+
+// PollNow polls the sources of the subscription with id as soon as possible.
+func (d *ShowRSSDownloader) PollNow(id string) error {
+	sub, err := d.DB.getSubscription(id)
+	if err != nil {
+		return err
+	}
+	if sub.Paused {
+		return ErrSubscriptionPaused
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rm, ok := d.monitors[id]
+	if !ok {
+		return errNotStarted
+	}
+	for _, entryID := range rm.ids {
+		d.scheduler.Trigger(entryID)
+	}
+	return nil
+}
+
+// FeedStatuses returns the status of the feed sources of all subscriptions.
+func (d *ShowRSSDownloader) FeedStatuses() ([]SubscriptionStatus, error) {
+	subs, err := d.DB.listSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statuses := make([]SubscriptionStatus, 0, len(subs))
+	for _, sub := range subs {
+		st := SubscriptionStatus{
+			ID:      sub.ID,
+			Paused:  sub.Paused,
+			Sources: []FeedStatus{},
+		}
+		if rm, ok := d.monitors[sub.ID]; ok {
+			for _, h := range rm.health {
+				st.Sources = append(st.Sources, h.Status())
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Subscriptions returns all subscriptions in the registry.
+func (d *ShowRSSDownloader) Subscriptions() ([]Subscription, error) {
+	return d.DB.listSubscriptions()
+}
+
+// AddSubscription adds feed to the subscription registry and starts
+// monitoring it.
+func (d *ShowRSSDownloader) AddSubscription(feed Feed) (Subscription, error) {
+	sub := newSubscription(feed)
+	if _, err := d.DB.getSubscription(sub.ID); err == nil {
+		return sub, ErrSubscriptionExists
+	} else if !errors.Is(err, ErrSubscriptionNotFound) {
+		return sub, err
+	}
+	if err := d.startMonitor(sub); err != nil {
+		return sub, err
+	}
+	if err := d.DB.putSubscription(sub); err != nil {
+		d.stopMonitor(sub.ID)
+		return sub, err
+	}
+	return sub, nil
+}
+
+// SetSubscriptionPaused pauses or resumes the subscription with id.
+func (d *ShowRSSDownloader) SetSubscriptionPaused(id string, paused bool) (Subscription, error) {
+	sub, err := d.DB.getSubscription(id)
+	if err != nil {
+		return sub, err
+	}
+	if paused {
+		d.stopMonitor(id)
+	} else if err := d.startMonitor(sub); err != nil {
+		return sub, err
+	}
+	sub.Paused = paused
+	sub.Updated = time.Now()
+	return sub, d.DB.putSubscription(sub)
+}
+
+// RemoveSubscription stops monitoring the subscription with id and removes it
+// from the registry.
+func (d *ShowRSSDownloader) RemoveSubscription(id string) error {
+	if err := d.DB.deleteSubscription(id); err != nil {
+		return err
+	}
+	d.stopMonitor(id)
+	d.resetSubscriptionNotifiers(id)
+	return nil
+}
@@ -0,0 +1,152 @@
+package showrss
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Release describes the quality of a release, parsed from its title.
+type Release struct {
+	Resolution string `json:"resolution,omitempty"` // 2160p, 1080p, 720p, 576p, 480p
+	Source     string `json:"source,omitempty"`     // bluray, webdl, webrip, web, hdtv, dvd, sdtv
+	Codec      string `json:"codec,omitempty"`      // x265, x264, av1, xvid
+	Proper     bool   `json:"proper,omitempty"`
+	Repack     bool   `json:"repack,omitempty"`
+	Group      string `json:"group,omitempty"`
+}
+
+type releasePattern struct {
+	re    *regexp.Regexp
+	value string
+}
+
+var (
+	resolutionPatterns = []releasePattern{
+		{regexp.MustCompile(`(?i)\b(2160p|4k|uhd)\b`), "2160p"},
+		{regexp.MustCompile(`(?i)\b1080[pi]\b`), "1080p"},
+		{regexp.MustCompile(`(?i)\b720p\b`), "720p"},
+		{regexp.MustCompile(`(?i)\b576p\b`), "576p"},
+		{regexp.MustCompile(`(?i)\b480p\b`), "480p"},
+	}
+	sourcePatterns = []releasePattern{
+		{regexp.MustCompile(`(?i)\b(blu-?ray|bdrip|brrip|bdremux)\b`), "bluray"},
+		{regexp.MustCompile(`(?i)\bweb[-. ]?dl\b`), "webdl"},
+		{regexp.MustCompile(`(?i)\bweb-?rip\b`), "webrip"},
+		{regexp.MustCompile(`(?i)\bweb\b`), "web"},
+		{regexp.MustCompile(`(?i)\bhdtv\b`), "hdtv"},
+		{regexp.MustCompile(`(?i)\bdvd(rip)?\b`), "dvd"},
+		{regexp.MustCompile(`(?i)\b(pdtv|sdtv|dsr)\b`), "sdtv"},
+	}
+	codecPatterns = []releasePattern{
+		{regexp.MustCompile(`(?i)\b(x265|h\.?265|hevc)\b`), "x265"},
+		{regexp.MustCompile(`(?i)\b(x264|h\.?264|avc)\b`), "x264"},
+		{regexp.MustCompile(`(?i)\bav1\b`), "av1"},
+		{regexp.MustCompile(`(?i)\bxvid\b`), "xvid"},
+	}
+	properRe = regexp.MustCompile(`(?i)\bproper\b`)
+	repackRe = regexp.MustCompile(`(?i)\b(repack|rerip)\b`)
+	groupRe  = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\s*\[[^\]]*\])?(?:\.(?:mkv|mp4|avi))?$`)
+)
+
+func matchPattern(patterns []releasePattern, s string) string {
+	for _, p := range patterns {
+		if p.re.MatchString(s) {
+			return p.value
+		}
+	}
+	return ""
+}
+
+// ParseRelease parses the release quality from a release title.
+func ParseRelease(title string) Release {
+	title = strings.TrimSpace(title)
+	r := Release{
+		Resolution: matchPattern(resolutionPatterns, title),
+		Source:     matchPattern(sourcePatterns, title),
+		Codec:      matchPattern(codecPatterns, title),
+		Proper:     properRe.MatchString(title),
+		Repack:     repackRe.MatchString(title),
+	}
+	if m := groupRe.FindStringSubmatch(title); m != nil {
+		r.Group = m[1]
+	}
+	return r
+}
+
+// RankingProfile ranks releases. Values earlier in each list are preferred,
+// resolution weighs more than source which weighs more than codec. Values
+// not in a list rank below all listed values.
+type RankingProfile struct {
+	Resolutions []string
+	Sources     []string
+	Codecs      []string
+}
+
+// DefaultRankingProfile prefers higher resolutions and better sources.
+var DefaultRankingProfile = RankingProfile{
+	Resolutions: []string{"2160p", "1080p", "720p", "576p", "480p"},
+	Sources:     []string{"bluray", "webdl", "web", "webrip", "hdtv", "dvd", "sdtv"},
+	Codecs:      []string{"x265", "x264", "av1", "xvid"},
+}
+
+func listScore(list []string, v string) int {
+	for i, s := range list {
+		if strings.EqualFold(s, v) {
+			return len(list) - i
+		}
+	}
+	return 0
+}
+
+// tiers returns the scores of r by tier, most important first. A PROPER or
+// REPACK ranks above the same release without it.
+func (p RankingProfile) tiers(r Release) [4]int {
+	var fix int
+	if r.Proper || r.Repack {
+		fix = 1
+	}
+	return [4]int{
+		listScore(p.Resolutions, r.Resolution),
+		listScore(p.Sources, r.Source),
+		listScore(p.Codecs, r.Codec),
+		fix,
+	}
+}
+
+// Compare returns a positive number if a ranks above b, a negative number if
+// b ranks above a and zero if they rank equal. The tiers are compared in
+// order, a lower tier only decides between releases equal in all higher
+// tiers.
+func (p RankingProfile) Compare(a, b Release) int {
+	ta, tb := p.tiers(a), p.tiers(b)
+	for i := range ta {
+		if ta[i] != tb[i] {
+			return ta[i] - tb[i]
+		}
+	}
+	return 0
+}
+
+// Rank returns the rank of r as a single number, higher is better. It is
+// only stored in the episode index for versions which compared ranks. More
+// than nine codecs or 99 sources overflow into the next tier, releases are
+// ordered by Compare.
+func (p RankingProfile) Rank(r Release) int {
+	t := p.tiers(r)
+	return t[0]*10000 + t[1]*100 + t[2]*10 + t[3]
+}
+
+// sortEpisodes sorts episodes by release rank, best first.
+func (p RankingProfile) sortEpisodes(episodes []Episode) {
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return p.Compare(episodes[i].Release, episodes[j].Release) > 0
+	})
+}
+
+// RankingConfig configures adding only the best release of each episode.
+type RankingConfig struct {
+	Enabled    bool
+	Profile    RankingProfile
+	DeleteData bool // delete the data of torrents replaced by a better release, same as DuplicateConfig.DeleteData
+}
@@ -0,0 +1,63 @@
+package showrss
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRankingProfileCompare(t *testing.T) {
+	p := DefaultRankingProfile
+	tests := []struct {
+		a, b string
+		want int // sign
+	}{
+		{"Show S01E01 1080p HDTV x264-GROUP", "Show S01E01 720p BluRay x265-GROUP", 1},
+		{"Show S01E01 720p WEB-DL x264-GROUP", "Show S01E01 720p HDTV x265-GROUP", 1},
+		{"Show S01E01 720p WEB-DL x265-GROUP", "Show S01E01 720p WEB-DL x264-GROUP", 1},
+		{"Show S01E01 720p WEB-DL x264 REPACK-GROUP", "Show S01E01 720p WEB-DL x264-GROUP", 1},
+		{"Show S01E01 720p WEB-DL x264-GROUP", "Show S01E01 720p WEB-DL x264-OTHER", 0},
+		{"Show S01E01 720p WEB-DL x264-GROUP", "Show S01E01 WEB-DL x264-GROUP", 1},
+		{"Show S01E01 480p HDTV xvid-GROUP", "Show S01E01 1080p HDTV x264-GROUP", -1},
+	}
+	for _, tt := range tests {
+		a, b := ParseRelease(tt.a), ParseRelease(tt.b)
+		if got := sign(p.Compare(a, b)); got != tt.want {
+			t.Errorf("compare %s with %s: %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := sign(p.Compare(b, a)); got != -tt.want {
+			t.Errorf("compare %s with %s: %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestRankingProfileCompareLongLists(t *testing.T) {
+	p := RankingProfile{Resolutions: []string{"1080p", "720p"}}
+	for i := 0; i < 150; i++ {
+		p.Sources = append(p.Sources, fmt.Sprintf("source%d", i))
+	}
+	p.Sources[0] = "bluray"
+	for i := 0; i < 20; i++ {
+		p.Codecs = append(p.Codecs, fmt.Sprintf("codec%d", i))
+	}
+	p.Codecs[0] = "x265"
+	best720 := Release{Resolution: "720p", Source: "bluray", Codec: "x265"}
+	worst1080 := Release{Resolution: "1080p"}
+	if p.Compare(worst1080, best720) <= 0 {
+		t.Errorf("%+v does not rank above %+v", worst1080, best720)
+	}
+	bestCodec := Release{Resolution: "720p", Codec: "x265"}
+	otherSource := Release{Resolution: "720p", Source: "source149"}
+	if p.Compare(otherSource, bestCodec) <= 0 {
+		t.Errorf("%+v does not rank above %+v", otherSource, bestCodec)
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package showrss
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pborzenkov/go-transmission/transmission"
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Replace reasons recorded on replaced and replacing items.
+const (
+	ReplaceReasonRepack = "repack"
+	ReplaceReasonRank   = "better rank"
+	ReplaceReasonPolicy = "replace policy"
+)
+
+// isRepackOf reports whether item is a PROPER or REPACK of the release in
+// entry, by the same group from the same source in the same resolution.
+func isRepackOf(item Episode, entry *episodeIndexEntry) bool {
+	r, er := item.Release, entry.Release
+	if !r.Proper && !r.Repack {
+		return false
+	}
+	return er.Group != "" && strings.EqualFold(r.Group, er.Group) &&
+		r.Source == er.Source &&
+		r.Resolution == er.Resolution
+}
+
+// pendingRemoval is a replaced torrent which is stopped and removed together
+// with its data once the replacing torrent has completed.
+type pendingRemoval struct {
+	InfoHash   string    `json:"info_hash"`
+	ReplacedBy string    `json:"replaced_by"`
+	Created    time.Time `json:"created"`
+}
+
+// deleteReplacedData reports whether the data of replaced torrents is
+// deleted.
+func (d *ShowRSSDownloader) deleteReplacedData() bool {
+	return d.Duplicates.DeleteData || d.Ranking.DeleteData
+}
+
+// replaceTorrent removes the torrent with infoHash which has been replaced by
+// item. If the data should be deleted the torrent is only stopped and removed
+// by processPendingRemovals once item has completed.
+func (d *ShowRSSDownloader) replaceTorrent(ctx context.Context, tx *bolt.Tx, infoHash string, item Episode) error {
+	if !d.deleteReplacedData() {
+		return d.removeTorrent(ctx, infoHash, false)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := d.TC.StopTorrents(ctx, transmission.IDs(transmission.Hash(infoHash)))
+	observeRPC("torrent-stop", start, err)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&pendingRemoval{
+		InfoHash:   infoHash,
+		ReplacedBy: item.InfoHash,
+		Created:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketPendingRemovals).Put([]byte(infoHash), data)
+}
+
+// markReplaced records on the added item with infoHash that it has been
+// replaced by replacedBy.
+func markReplaced(tx *bolt.Tx, infoHash, replacedBy, reason string) error {
+	bucket := tx.Bucket(bucketAdded)
+	data := bucket.Get([]byte(infoHash))
+	if data == nil {
+		return nil
+	}
+	var dbep dbEpisode
+	if err := json.Unmarshal(data, &dbep); err != nil {
+		return err
+	}
+	dbep.ReplacedBy = replacedBy
+	dbep.ReplaceReason = reason
+	dbep.State = EpisodeStateReplaced
+	dbep.Updated = time.Now()
+	data, err := json.Marshal(&dbep)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(infoHash), data)
+}
+
+// processPendingRemovals periodically removes replaced torrents and their
+// data once the replacing torrents have completed.
+func (d *ShowRSSDownloader) processPendingRemovals(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		if err := d.removeCompletedReplacements(ctx); err != nil {
+			log.Err(err).Msg("error processing pending removals")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *ShowRSSDownloader) removeCompletedReplacements(ctx context.Context) error {
+	var pending []pendingRemoval
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPendingRemovals).ForEach(func(k, v []byte) error {
+			var pr pendingRemoval
+			if err := json.Unmarshal(v, &pr); err != nil {
+				return err
+			}
+			pending = append(pending, pr)
+			return nil
+		})
+	})
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	for _, pr := range pending {
+		logger := log.With().
+			Str("info_hash", pr.InfoHash).
+			Str("replaced_by", pr.ReplacedBy).
+			Logger()
+		done, found, err := d.torrentDone(ctx, pr.ReplacedBy)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !found:
+			logger.Warn().Msg("replacing torrent is gone from transmission, keeping replaced torrent")
+		case !done:
+			continue
+		default:
+			if err := d.removeTorrent(ctx, pr.InfoHash, true); err != nil {
+				logger.Err(err).Msg("could not remove replaced torrent")
+				continue
+			}
+			logger.Info().Msg("removed replaced torrent and its data")
+			if dbep, err := d.DB.getEpisode(pr.InfoHash); err == nil {
+				e := episodeEvent(EventRemoved, dbep.Subscription, dbep.Episode)
+				e.Reason = "replaced by " + pr.ReplacedBy
+				d.Events().Publish(e)
+			}
+		}
+		err = d.DB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketPendingRemovals).Delete([]byte(pr.InfoHash))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// torrentDone reports whether the torrent with infoHash has completed
+// downloading and whether it exists in transmission.
+func (d *ShowRSSDownloader) torrentDone(ctx context.Context, infoHash string) (done, found bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	torrents, err := d.TC.GetTorrents(ctx,
+		transmission.IDs(transmission.Hash(infoHash)),
+		transmission.TorrentFieldHash, transmission.TorrentFieldDataDone,
+	)
+	observeRPC("torrent-get", start, err)
+	if err != nil {
+		return false, false, err
+	}
+	for _, t := range torrents {
+		if string(t.Hash) == infoHash {
+			return t.DataDone >= 1, true, nil
+		}
+	}
+	return false, false, nil
+}
@@ -0,0 +1,96 @@
+package showrss
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCheckDuplicateRepack(t *testing.T) {
+	const added = "Show S01E01 1080p WEB-DL x264-GROUP"
+	tests := []struct {
+		title   string
+		ranking bool
+		replace bool
+	}{
+		{"Show S01E01 1080p WEB-DL x264 REPACK-GROUP", false, true},
+		{"Show S01E01 1080p WEB-DL x264 PROPER-OTHER", false, false},
+		{"Show S01E01 1080p HDTV x264 REPACK-GROUP", false, false},
+		{"Show S01E01 720p WEB-DL x264 REPACK-GROUP", false, false},
+		{"Show S01E01 1080p WEB-DL x264 REPACK-GROUP", true, true},
+		{"Show S01E01 1080p WEB-DL xvid REPACK-GROUP", true, false},
+	}
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	item := Episode{Title: added, InfoHash: "a", ShowID: 1}
+	item.parseTitle()
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putEpisodeIndex(tx, item.Identity(), newEpisodeIndexEntry(item))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		d := &ShowRSSDownloader{DB: db}
+		d.Duplicates = DuplicateConfig{Policy: DuplicateSkip, Repacks: true}
+		d.Ranking = RankingConfig{Enabled: tt.ranking, Profile: DefaultRankingProfile}
+		repack := Episode{Title: tt.title, InfoHash: "b", ShowID: 1}
+		repack.parseTitle()
+		err := db.View(func(tx *bolt.Tx) error {
+			add, replaces, _, err := d.checkDuplicate(tx, repack)
+			if replace := add && replaces == "a"; replace != tt.replace {
+				t.Errorf("%s (ranking %v): replace %v, want %v", tt.title, tt.ranking, replace, tt.replace)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckDuplicateRankedEntry(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	d := &ShowRSSDownloader{DB: db}
+	d.Ranking = RankingConfig{Enabled: true, Profile: DefaultRankingProfile}
+	added := Episode{Title: "Show S01E01 1080p WEB-DL x264-GROUP", InfoHash: "a", ShowID: 1}
+	added.parseTitle()
+	// an entry indexed before the release was stored
+	entry := episodeIndexEntry{InfoHash: "a", Title: added.Title, Rank: d.Ranking.Profile.Rank(added.Release)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putEpisodeIndex(tx, added.Identity(), entry)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		title   string
+		replace bool
+	}{
+		{"Show S01E01 720p HDTV x264-OTHER", false},
+		{"Show S01E01 1080p WEB-DL x264-OTHER", false},
+		{"Show S01E01 2160p WEB-DL x265-OTHER", true},
+	}
+	for _, tt := range tests {
+		item := Episode{Title: tt.title, InfoHash: "b", ShowID: 1}
+		item.parseTitle()
+		err := db.View(func(tx *bolt.Tx) error {
+			add, replaces, _, err := d.checkDuplicate(tx, item)
+			if replace := add && replaces == "a"; replace != tt.replace || add != tt.replace {
+				t.Errorf("%s: add %v, replaces %q, want replace %v", tt.title, add, replaces, tt.replace)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package showrss
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+// Clock is the time source of the Scheduler.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// SchedulerConfig configures feed polling.
+type SchedulerConfig struct {
+	MaxConcurrent int           // max concurrent feed fetches
+	HostInterval  time.Duration // min time between fetch starts to the same host
+	Jitter        float64       // poll interval jitter as a fraction of the feed ttl
+	StartSpread   time.Duration // first polls are spread randomly over this duration
+}
+
+// SchedulerOpts returns the scheduler options for the configuration.
+func (c SchedulerConfig) SchedulerOpts() []schedulerOpt {
+	return []schedulerOpt{
+		SchedulerMaxConcurrent(c.MaxConcurrent),
+		SchedulerHostInterval(c.HostInterval),
+		SchedulerJitter(c.Jitter),
+		SchedulerStartSpread(c.StartSpread),
+	}
+}
+
+type schedulerOpt func(s *Scheduler)
+
+// SchedulerClock sets the time source, for testing.
+func SchedulerClock(clock Clock) schedulerOpt {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+// SchedulerRand sets the source of the poll jitter, for testing.
+func SchedulerRand(r *rand.Rand) schedulerOpt {
+	return func(s *Scheduler) {
+		s.rand = r
+	}
+}
+
+// SchedulerTTL overrides the poll interval of all feeds.
+func SchedulerTTL(ttl time.Duration) schedulerOpt {
+	return func(s *Scheduler) {
+		s.ttl = ttl
+	}
+}
+
+// SchedulerAirSchedule makes the scheduler adapt the poll interval of feeds
+// to the air times of their shows.
+func SchedulerAirSchedule(a *AirSchedule) schedulerOpt {
+	return func(s *Scheduler) {
+		s.airSchedule = a
+	}
+}
+
+// SchedulerEvents makes the scheduler publish a feed polled event for each
+// fetch to hub.
+func SchedulerEvents(hub *EventHub) schedulerOpt {
+	return func(s *Scheduler) {
+		s.events = hub
+	}
+}
+
+func SchedulerMaxConcurrent(n int) schedulerOpt {
+	return func(s *Scheduler) {
+		if n > 0 {
+			s.maxConcurrent = n
+		}
+	}
+}
+
+func SchedulerHostInterval(d time.Duration) schedulerOpt {
+	return func(s *Scheduler) {
+		s.hostInterval = d
+	}
+}
+
+func SchedulerJitter(f float64) schedulerOpt {
+	return func(s *Scheduler) {
+		s.jitter = f
+	}
+}
+
+func SchedulerStartSpread(d time.Duration) schedulerOpt {
+	return func(s *Scheduler) {
+		s.startSpread = d
+	}
+}
+
+// Scheduler polls all feed sources. It spreads polls with jitter and limits
+// the number of concurrent fetches and the rate of fetches per host.
+type Scheduler struct {
+	clock         Clock
+	rand          *rand.Rand
+	ttl           time.Duration
+	maxConcurrent int
+	hostInterval  time.Duration
+	jitter        float64
+	startSpread   time.Duration
+	airSchedule   *AirSchedule
+	events        *EventHub
+
+	mu       sync.Mutex
+	entries  map[string]*pollEntry
+	hostNext map[string]time.Time // earliest next fetch start per host
+	wake     chan struct{}
+	wg       sync.WaitGroup // running polls
+}
+
+// DeliverFunc receives the episodes of a fetched feed.
+type DeliverFunc func(ctx context.Context, item Episode) error
+
+type pollEntry struct {
+	id      string
+	src     FeedSource
+	host    string
+	health  *FeedHealth
+	deliver DeliverFunc
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	next        time.Time
+	ttl         time.Duration
+	externalIDs []int // shows in the last fetched channel
+	running     bool
+	triggered   bool // Trigger was called while running, poll again when done
+	failures    int
+	bo          *backoff.ExponentialBackOff
+}
+
+func NewScheduler(opts ...schedulerOpt) *Scheduler {
+	s := &Scheduler{
+		clock:         systemClock{},
+		maxConcurrent: 4,
+		entries:       make(map[string]*pollEntry),
+		hostNext:      make(map[string]time.Time),
+		wake:          make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.rand == nil {
+		s.rand = rand.New(rand.NewSource(s.clock.Now().UnixNano()))
+	}
+	return s
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Add schedules polling of src under id. The episodes of each changed
+// response are passed to deliver. The state of the source is reported to
+// health, which may be nil.
+func (s *Scheduler) Add(id string, src FeedSource, health *FeedHealth, deliver DeliverFunc) {
+	var host string
+	if u, err := url.Parse(src.URL()); err == nil {
+		host = u.Host
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if old, ok := s.entries[id]; ok {
+		old.cancel()
+	}
+	next := s.clock.Now()
+	if s.startSpread > 0 {
+		next = next.Add(time.Duration(s.rand.Int63n(int64(s.startSpread))))
+	}
+	s.entries[id] = &pollEntry{
+		id:      id,
+		src:     src,
+		host:    host,
+		health:  health,
+		deliver: deliver,
+		ctx:     ctx,
+		cancel:  cancel,
+		next:    next,
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Remove stops polling id.
+func (s *Scheduler) Remove(id string) {
+	s.mu.Lock()
+	if e, ok := s.entries[id]; ok {
+		e.cancel()
+		delete(s.entries, id)
+		source := e.src.String()
+		metricFeedConsecutiveFailures.delete(source)
+		metricFeedBackoff.delete(source)
+		metricFeedLastSuccess.delete(source)
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Trigger polls id as soon as the concurrency and host limits allow. If id is
+// being polled it is polled again once that poll is done.
+func (s *Scheduler) Trigger(id string) bool {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	switch {
+	case ok && e.running:
+		e.triggered = true
+	case ok:
+		e.next = s.clock.Now()
+	}
+	s.mu.Unlock()
+	s.signal()
+	return ok
+}
+
+// Run polls the scheduled sources and refreshes the air schedules until ctx
+// is done. Run must only be called once.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.airSchedule != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.airSchedule.Run(ctx)
+		}()
+	}
+	sem := make(chan struct{}, s.maxConcurrent)
+	for {
+		wait := s.dispatch(sem)
+		var timerC <-chan time.Time
+		var timer Timer
+		if wait >= 0 {
+			timer = s.clock.NewTimer(wait)
+			timerC = timer.C()
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			s.mu.Lock()
+			for _, e := range s.entries {
+				e.cancel()
+			}
+			s.mu.Unlock()
+			s.wg.Wait()
+			return ctx.Err()
+		case <-timerC:
+		case <-s.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// dispatch starts polls for all due entries and returns the time until the
+// next entry is due, or -1 if no entry is waiting for its time.
+func (s *Scheduler) dispatch(sem chan struct{}) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*pollEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		if !e.running {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].next.Before(entries[j].next) })
+
+	now := s.clock.Now()
+	wait := time.Duration(-1)
+	for _, e := range entries {
+		due := e.next
+		if hn := s.hostNext[e.host]; hn.After(due) {
+			due = hn
+		}
+		if due.After(now) {
+			if d := due.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			// at the concurrency limit, a finishing poll wakes the scheduler
+			continue
+		}
+		e.running = true
+		s.hostNext[e.host] = now.Add(s.hostInterval)
+		s.wg.Add(1)
+		go s.poll(e, sem)
+	}
+	return wait
+}
+
+func (s *Scheduler) poll(e *pollEntry, sem chan struct{}) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		e.running = false
+		if e.triggered {
+			e.triggered = false
+			e.next = s.clock.Now()
+		}
+		s.mu.Unlock()
+		s.signal()
+	}()
+	logger := log.With().Str("source", e.src.String()).Logger()
+
+	source := e.src.String()
+	start := time.Now()
+	// the handled channel is stored, so also the first poll after a restart
+	// is conditional
+	channel, err := e.src.Fetch(e.ctx, true)
+	<-sem
+	if e.ctx.Err() != nil {
+		// removed or shutting down
+		return
+	}
+	metricFeedFetchDuration.observe(time.Since(start).Seconds(), source)
+	polled := Event{Type: EventFeedPolled, Source: source}
+	switch {
+	case errors.Is(err, ErrNotModified):
+		logger.Debug().Msg("feed not modified")
+		polled.Result = "not_modified"
+		err = nil
+	case err == nil && channel.NotModified:
+		logger.Debug().Msg("feed not modified, re-delivering the last response")
+		polled.Result = "not_modified"
+		polled.Items = len(channel.Episodes)
+	case err != nil:
+		polled.Result = "error"
+		polled.Error = err.Error()
+		metricFeedFailures.add(1, source, string(ClassifyError(err)))
+	default:
+		polled.Result = "ok"
+		polled.Items = len(channel.Episodes)
+	}
+	metricFeedFetches.add(1, source, polled.Result)
+	s.events.Publish(polled)
+	var interval time.Duration
+	if err == nil {
+		// only the running poll uses the entry fields besides next and running
+		if channel != nil {
+			e.ttl = channel.TTLDuration()
+			if ids := externalIDs(channel.Episodes); len(ids) > 0 {
+				e.externalIDs = ids
+			}
+		}
+		interval = e.ttl
+		switch {
+		case s.ttl > 0:
+			interval = s.ttl
+		case s.airSchedule != nil:
+			interval = s.airSchedule.Interval(e.externalIDs, s.clock.Now(), e.ttl)
+		}
+	}
+
+	s.mu.Lock()
+	now := s.clock.Now()
+	if err != nil {
+		if e.bo == nil {
+			e.bo = &backoff.ExponentialBackOff{
+				InitialInterval:     2 * time.Second,
+				RandomizationFactor: 0.5,
+				Multiplier:          1.5,
+				MaxInterval:         time.Hour,
+				MaxElapsedTime:      0, // never stop
+				Clock:               s.clock,
+			}
+			e.bo.Reset()
+		}
+		e.failures++
+		next := e.bo.NextBackOff()
+		semantic := ClassifyError(err) == ErrorClassSemantic
+		if semantic {
+			// retrying soon will not help, but a rejected feed may come back
+			next = e.bo.MaxInterval
+		}
+		if ra := retryAfter(err); ra > next {
+			next = ra
+		}
+		e.next = now.Add(next)
+		failures := e.failures
+		s.mu.Unlock()
+		metricFeedConsecutiveFailures.set(float64(failures), source)
+		metricFeedBackoff.set(next.Seconds(), source)
+		if semantic {
+			e.health.rejected(err, next)
+			logger.Error().Err(err).Msgf("feed rejected, failures: %v next: %v", failures, next)
+			return
+		}
+		e.health.failure(err, next)
+		logger.Warn().Msgf("failures: %v next:%v err: %v", failures, next, err)
+		return
+	}
+	e.bo = nil
+	e.failures = 0
+	ttl := interval
+	if s.jitter > 0 {
+		ttl += time.Duration((s.rand.Float64()*2 - 1) * s.jitter * float64(ttl))
+	}
+	e.next = now.Add(ttl)
+	s.mu.Unlock()
+	metricFeedConsecutiveFailures.set(0, source)
+	metricFeedBackoff.set(0, source)
+	metricFeedLastSuccess.set(float64(time.Now().Unix()), source)
+	e.health.success(ttl)
+	logger.Info().Msgf("will wait for %v", ttl)
+
+	if channel == nil {
+		return
+	}
+	logger.Debug().Msgf("episodes: %v", len(channel.Episodes))
+	for _, item := range channel.Episodes {
+		if err := e.deliver(e.ctx, item); err != nil {
+			if e.ctx.Err() == nil {
+				logger.Err(err).Msg("error delivering episode")
+			}
+			return
+		}
+	}
+	e.src.Handled(channel)
+}
@@ -0,0 +1,285 @@
+package showrss
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock which only moves when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock by d and fires the timers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = timers
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	ch    chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeSource is a FeedSource which reports each fetch and returns the result
+// of fetch.
+type fakeSource struct {
+	url     string
+	fetches chan string
+	fetch   func() (*Channel, error)
+}
+
+func (s *fakeSource) Fetch(ctx context.Context, conditional bool) (*Channel, error) {
+	s.fetches <- s.url
+	return s.fetch()
+}
+
+func (s *fakeSource) Handled(channel *Channel) {}
+func (s *fakeSource) URL() string              { return s.url }
+func (s *fakeSource) String() string           { return s.url }
+
+func newFakeSource(url string, fetches chan string, err error) *fakeSource {
+	return &fakeSource{
+		url:     url,
+		fetches: fetches,
+		fetch: func() (*Channel, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &Channel{Title: url, TTL: 30}, nil
+		},
+	}
+}
+
+func noDeliver(ctx context.Context, item Episode) error { return nil }
+
+func startScheduler(t *testing.T, opts ...schedulerOpt) (*Scheduler, *fakeClock) {
+	clock := newFakeClock()
+	s := NewScheduler(append([]schedulerOpt{SchedulerClock(clock), SchedulerRand(rand.New(rand.NewSource(1)))}, opts...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s, clock
+}
+
+// waitFetch waits for the next fetch and returns the source url.
+func waitFetch(t *testing.T, fetches chan string) string {
+	t.Helper()
+	select {
+	case url := <-fetches:
+		return url
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for fetch")
+	}
+	return ""
+}
+
+func noFetch(t *testing.T, fetches chan string) {
+	t.Helper()
+	select {
+	case url := <-fetches:
+		t.Fatalf("unexpected fetch of %s", url)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitIdle waits until the poll of id has finished and returns the time
+// until its next poll.
+func waitIdle(t *testing.T, s *Scheduler, clock *fakeClock, id string) time.Duration {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		e := s.entries[id]
+		running, next := e.running, e.next
+		s.mu.Unlock()
+		if !running {
+			return next.Sub(clock.Now())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timeout waiting for poll to finish")
+	return 0
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	s, clock := startScheduler(t)
+	fetches := make(chan string, 10)
+	s.Add("a", newFakeSource("http://a/", fetches, errors.New("connection refused")), nil, noDeliver)
+
+	interval := 2 * time.Second
+	for i := 0; i < 5; i++ {
+		waitFetch(t, fetches)
+		next := waitIdle(t, s, clock, "a")
+		if min, max := interval/2, interval*3/2; next < min || next > max {
+			t.Fatalf("retry %d in %v, want between %v and %v", i, next, min, max)
+		}
+		clock.Advance(next)
+		interval = interval * 3 / 2
+	}
+}
+
+func TestSchedulerRejectedFeedRetriesAtMaxInterval(t *testing.T) {
+	s, clock := startScheduler(t)
+	fetches := make(chan string, 10)
+	rejected := &HTTPError{StatusCode: 404}
+	s.Add("a", newFakeSource("http://a/", fetches, rejected), nil, noDeliver)
+
+	for i := 0; i < 2; i++ {
+		waitFetch(t, fetches)
+		if next := waitIdle(t, s, clock, "a"); next != time.Hour {
+			t.Fatalf("retry %d in %v, want %v", i, next, time.Hour)
+		}
+		clock.Advance(time.Hour)
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	const ttl = 10 * time.Minute
+	s, clock := startScheduler(t, SchedulerTTL(ttl), SchedulerJitter(0.2))
+	fetches := make(chan string, 10)
+	s.Add("a", newFakeSource("http://a/", fetches, nil), nil, noDeliver)
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 5; i++ {
+		waitFetch(t, fetches)
+		next := waitIdle(t, s, clock, "a")
+		if min, max := ttl*8/10, ttl*12/10; next < min || next > max {
+			t.Fatalf("poll %d in %v, want between %v and %v", i, next, min, max)
+		}
+		seen[next] = true
+		clock.Advance(next)
+	}
+	if len(seen) < 2 {
+		t.Fatalf("poll intervals are not jittered: %v", seen)
+	}
+}
+
+func TestSchedulerTriggerWhilePolling(t *testing.T) {
+	s, clock := startScheduler(t, SchedulerTTL(time.Hour))
+	fetches := make(chan string, 10)
+	src := newFakeSource("http://a/", fetches, nil)
+	release := make(chan struct{})
+	fetch := src.fetch
+	src.fetch = func() (*Channel, error) {
+		<-release
+		return fetch()
+	}
+	s.Add("a", src, nil, noDeliver)
+
+	waitFetch(t, fetches)
+	if !s.Trigger("a") {
+		t.Fatal("trigger: entry not found")
+	}
+	release <- struct{}{}
+	waitFetch(t, fetches)
+	release <- struct{}{}
+	if next := waitIdle(t, s, clock, "a"); next != time.Hour {
+		t.Fatalf("next poll in %v, want %v", next, time.Hour)
+	}
+	noFetch(t, fetches)
+}
+
+func TestSchedulerHostInterval(t *testing.T) {
+	s, clock := startScheduler(t, SchedulerHostInterval(time.Minute), SchedulerTTL(time.Hour))
+	fetches := make(chan string, 10)
+	s.Add("a", newFakeSource("http://host/a", fetches, nil), nil, noDeliver)
+	s.Add("b", newFakeSource("http://host/b", fetches, nil), nil, noDeliver)
+	s.Add("c", newFakeSource("http://other/c", fetches, nil), nil, noDeliver)
+
+	got := map[string]bool{waitFetch(t, fetches): true, waitFetch(t, fetches): true}
+	if !got["http://other/c"] || len(got) != 2 {
+		t.Fatalf("first fetches %v, want one per host", got)
+	}
+	noFetch(t, fetches)
+	clock.Advance(30 * time.Second)
+	noFetch(t, fetches)
+	clock.Advance(30 * time.Second)
+	url := waitFetch(t, fetches)
+	if got[url] || url == "http://other/c" {
+		t.Fatalf("fetch of %s after the host interval", url)
+	}
+}
+
+func TestSchedulerMaxConcurrent(t *testing.T) {
+	s, _ := startScheduler(t, SchedulerMaxConcurrent(1), SchedulerTTL(time.Hour))
+	fetches := make(chan string, 10)
+	release := make(chan struct{})
+	for _, url := range []string{"http://a/", "http://b/"} {
+		src := newFakeSource(url, fetches, nil)
+		fetch := src.fetch
+		src.fetch = func() (*Channel, error) {
+			<-release
+			return fetch()
+		}
+		s.Add(url, src, nil, noDeliver)
+	}
+
+	first := waitFetch(t, fetches)
+	noFetch(t, fetches)
+	release <- struct{}{}
+	if second := waitFetch(t, fetches); second == first {
+		t.Fatalf("%s fetched twice", first)
+	}
+	release <- struct{}{}
+}
@@ -1,34 +1,196 @@
 package showrss
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/pborzenkov/go-transmission/transmission"
 	"github.com/some-programs/transmission-showrss/pkg/log"
-	bolt "go.etcd.io/bbolt"
 )
 
-func APIServer(db *DB, tc *transmission.Client, bindAddr string) error {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		first := true
-		w.Write([]byte("["))
-		err := db.View(func(tx *bolt.Tx) error {
-			bucket := tx.Bucket(bucketAdded)
-			c := bucket.Cursor()
-			for k, v := c.First(); k != nil; k, v = c.Next() {
-				if !first {
-					w.Write([]byte(","))
-				}
-				w.Write(v)
-				first = false
-			}
-			return nil
-		})
+// APIServer serves the api until ctx is done. Health and readiness probes
+// and the static dashboard files are served without authentication.
+func APIServer(ctx context.Context, d *ShowRSSDownloader, config APIConfig) error {
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		return err
+	}
+	if auth == nil {
+		log.Warn().Msg("no api credentials configured, the api is not protected")
+	}
+
+	srv := &http.Server{
+		Addr:    config.Addr,
+		Handler: newAPIHandler(d, auth),
+		// cancel requests on shutdown, event streams never end on their own
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if config.TLSCert != "" || config.TLSKey != "" {
+			errCh <- srv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// newAPIHandler returns the handler of the api server. Requests to the api
+// are authenticated by auth.
+func newAPIHandler(d *ShowRSSDownloader, auth *authenticator) http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// unversioned list of the episode history, use /api/v1/episodes
+		if r.URL.Path != "/" {
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		items, _, err := d.DB.listEpisodes(EpisodeFilter{})
 		if err != nil {
-			log.Err(err).Msg("")
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, items)
+	})
+	api.HandleFunc("/subscriptions", legacySubscriptionsHandler(d))
+	api.HandleFunc("/subscriptions/", legacySubscriptionsHandler(d))
+	api.HandleFunc("/feeds", feedsHandler(d))
+	api.HandleFunc("/metrics", MetricsHandler(d.DB))
+	api.HandleFunc("/events", eventsHandler(d))
+	protected := auth.handler(api)
+	v1 := auth.handler(apiV1Handler(d))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && wantsHTML(r) {
+			http.Redirect(w, r, dashboardPrefix, http.StatusFound)
+			return
 		}
-		w.Write([]byte("]"))
+		protected.ServeHTTP(w, r)
 	})
+	mux.Handle(dashboardPrefix, dashboardHandler())
+	mux.HandleFunc("/healthz", probeHandler(func(r *http.Request) ProbeResult { return d.Healthy() }))
+	mux.HandleFunc("/readyz", probeHandler(func(r *http.Request) ProbeResult { return d.Ready(r.Context()) }))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// escaped subscription ids unescape to paths with slashes which
+		// ServeMux would clean and redirect, the v1 api is dispatched on
+		// the escaped path instead
+		if strings.HasPrefix(r.URL.Path, apiV1Prefix) {
+			v1.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// legacySubscriptionsHandler serves the unversioned subscription endpoints
+// as aliases of the v1 api, with the subscription id in the id query
+// parameter:
+//
+//	GET, POST /subscriptions            GET, POST /api/v1/subscriptions
+//	DELETE    /subscriptions?id=        DELETE /api/v1/subscriptions/{id}
+//	POST      /subscriptions/pause?id=  PATCH /api/v1/subscriptions/{id} {"paused": true}
+//	POST      /subscriptions/resume?id= PATCH /api/v1/subscriptions/{id} {"paused": false}
+//	POST      /subscriptions/poll?id=   POST /api/v1/subscriptions/{id}/poll
+func legacySubscriptionsHandler(d *ShowRSSDownloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		switch r.URL.Path {
+		case "/subscriptions":
+			if r.Method == http.MethodDelete {
+				subscriptionV1Handler(w, r, d, id)
+				return
+			}
+			subscriptionsV1Handler(w, r, d)
+		case "/subscriptions/pause", "/subscriptions/resume":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+				return
+			}
+			paused := r.URL.Path == "/subscriptions/pause"
+			patchSubscription(w, d, id, subscriptionPatch{Paused: &paused})
+		case "/subscriptions/poll":
+			subscriptionPollV1Handler(w, r, d, id)
+		default:
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+		}
+	}
+}
+
+// feedsHandler lists the state (pending, healthy, failing) of the feed
+// sources of all subscriptions.
+func feedsHandler(d *ShowRSSDownloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		statuses, err := d.FeedStatuses()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	}
+}
+
+// probeHandler serves the result of a health or readiness check, with status
+// 503 if the check failed.
+func probeHandler(probe func(r *http.Request) ProbeResult) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		result := probe(r)
+		status := http.StatusOK
+		if !result.OK {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, result)
+	}
+}
+
+func subscriptionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrSubscriptionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSubscriptionExists), errors.Is(err, ErrSubscriptionPaused):
+		return http.StatusConflict
+	case errors.Is(err, errNotStarted):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Err(err).Msg("error writing response")
+	}
+}
 
-	return http.ListenAndServe(bindAddr, nil)
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
 }
@@ -0,0 +1,123 @@
+package showrss
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// FeedSource is a feed which can be fetched into a Channel of episodes.
+type FeedSource interface {
+	// Fetch fetches the feed. If conditional is true and the feed has not
+	// changed since the last handled fetch, the last handled channel may be
+	// returned with NotModified set.
+	Fetch(ctx context.Context, conditional bool) (*Channel, error)
+	// Handled is called when all episodes of a fetched channel were
+	// delivered.
+	Handled(channel *Channel)
+	// URL returns the feed url.
+	URL() string
+	String() string
+}
+
+// Sources returns the sources of feed. For showrss feeds there is one source
+// per quality in the fallback chain, preferred quality first.
+func (c *Client) Sources(feed Feed) ([]FeedSource, error) {
+	switch feed.Type {
+	case FeedTypeUser, FeedTypeShow:
+		var sources []FeedSource
+		for _, q := range feed.Qualities() {
+			sources = append(sources, showRSSSource{client: c, feed: feed, quality: q})
+		}
+		return sources, nil
+	case FeedTypeRSS:
+		return []FeedSource{genericSource{client: c, feed: feed, parse: ParseGenericFeed}}, nil
+	case FeedTypeTorznab:
+		return []FeedSource{genericSource{client: c, feed: feed, parse: ParseTorznab}}, nil
+	}
+	return nil, fmt.Errorf("unknown feed type '%v'", feed.Type)
+}
+
+// showRSSSource is a showrss.info user or show feed in a single quality.
+type showRSSSource struct {
+	client  *Client
+	feed    Feed
+	quality Quality
+}
+
+func (s showRSSSource) URL() string {
+	if s.feed.Type == FeedTypeUser {
+		return s.client.userFeedURL(s.feed.ID, s.quality)
+	}
+	return s.client.showFeedURL(s.feed.ID, s.quality)
+}
+
+func (s showRSSSource) Fetch(ctx context.Context, conditional bool) (*Channel, error) {
+	return s.client.fetch(ctx, s.URL(), ParseRSS, conditional)
+}
+
+func (s showRSSSource) Handled(channel *Channel) {
+	s.client.handled(channel)
+}
+
+func (s showRSSSource) String() string {
+	return fmt.Sprintf("%v:%v", s.feed, s.quality.param())
+}
+
+// genericSource is a RSS 2.0, Atom or Torznab feed from any url.
+type genericSource struct {
+	client *Client
+	feed   Feed
+	parse  parseFunc
+}
+
+func (s genericSource) Fetch(ctx context.Context, conditional bool) (*Channel, error) {
+	channel, err := s.client.fetch(ctx, s.feed.URL, s.parse, conditional)
+	if err != nil {
+		return nil, err
+	}
+	showName := s.feed.ShowName
+	if showName == "" {
+		showName = channel.Title
+	}
+	for k := range channel.Episodes {
+		channel.Episodes[k].ShowName = showName
+		if !channel.NotModified {
+			// parse again without the show name
+			channel.Episodes[k].parseTitle()
+		}
+	}
+	return channel, nil
+}
+
+func (s genericSource) Handled(channel *Channel) {
+	s.client.handled(channel)
+}
+
+func (s genericSource) URL() string {
+	return s.feed.URL
+}
+
+func (s genericSource) String() string {
+	return s.feed.String()
+}
+
+// redactURL removes credentials and api keys from a feed url so that it can
+// be logged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	q := u.Query()
+	for _, k := range []string{"apikey", "passkey", "key", "token"} {
+		if q.Has(k) {
+			q.Set(k, "redacted")
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
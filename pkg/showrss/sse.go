@@ -0,0 +1,81 @@
+package showrss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+// eventsHandler streams the downloader events as server-sent events. The
+// types query parameter limits the stream to a comma separated list of event
+// types. Clients resuming a stream with Last-Event-ID first receive the kept
+// events they missed.
+func eventsHandler(d *ShowRSSDownloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+			return
+		}
+		types := make(map[EventType]bool)
+		if s := r.URL.Query().Get("types"); s != "" {
+			for _, t := range strings.Split(s, ",") {
+				types[EventType(strings.TrimSpace(t))] = true
+			}
+		}
+
+		var events <-chan Event
+		var cancel func()
+		if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			events, cancel = d.Events().SubscribeAfter("sse", id)
+		} else {
+			events, cancel = d.Events().Subscribe("sse")
+		}
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case e := <-events:
+				if len(types) > 0 && !types[e.Type] {
+					continue
+				}
+				data, err := json.Marshal(&e)
+				if err != nil {
+					log.Err(err).Msg("could not encode event")
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package showrss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/some-programs/transmission-showrss/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Quality is a showrss release quality.
+type Quality string
+
+const (
+	QualityAny Quality = "any"
+	QualitySD  Quality = "sd"
+	QualityHD  Quality = "hd"
+	QualityFHD Quality = "fhd"
+)
+
+func ParseQuality(s string) (Quality, error) {
+	switch q := Quality(strings.ToLower(strings.TrimSpace(s))); q {
+	case QualityAny, QualitySD, QualityHD, QualityFHD:
+		return q, nil
+	case "null":
+		return QualityAny, nil
+	}
+	return "", fmt.Errorf("unknown quality '%s'", s)
+}
+
+// param returns the value of the showrss quality url parameter.
+func (q Quality) param() string {
+	if q == "" || q == QualityAny {
+		return "null"
+	}
+	return string(q)
+}
+
+// Fallback is a lower priority quality which is accepted for an episode when
+// no release in a preferred quality has shown up within Delay.
+type Fallback struct {
+	Quality Quality       `json:"quality"`
+	Delay   time.Duration `json:"delay"`
+}
+
+type FeedType string
+
+const (
+	FeedTypeShow FeedType = "show"
+	FeedTypeUser FeedType = "user"
+
+	FeedTypeRSS     FeedType = "rss"     // generic RSS 2.0 or Atom feed
+	FeedTypeTorznab FeedType = "torznab" // torznab indexer api
+)
+
+// isShowRSS reports whether the feed type is a showrss.info feed.
+func (t FeedType) isShowRSS() bool {
+	return t == FeedTypeShow || t == FeedTypeUser
+}
+
+// Feed is a subscription to a showrss user or show feed, or to a generic feed
+// url.
+type Feed struct {
+	Type      FeedType   `json:"type"`
+	ID        int        `json:"id,omitempty"`
+	Quality   Quality    `json:"quality,omitempty"`
+	Fallbacks []Fallback `json:"fallbacks,omitempty"`
+
+	URL      string `json:"url,omitempty"`       // url of generic feeds
+	ShowName string `json:"show_name,omitempty"` // show name of generic feed items
+}
+
+func (f Feed) String() string {
+	if !f.Type.isShowRSS() {
+		return fmt.Sprintf("%s/%s", f.Type, redactURL(f.URL))
+	}
+	return fmt.Sprintf("%s/%d", f.Type, f.ID)
+}
+
+func (f Feed) Key() []byte {
+	if !f.Type.isShowRSS() {
+		return []byte(fmt.Sprintf("%s/%s", f.Type, f.URL))
+	}
+	return []byte(f.String())
+}
+
+// Qualities returns the preferred quality followed by the fallback chain.
+func (f Feed) Qualities() []Quality {
+	qs := []Quality{f.Quality}
+	for _, fb := range f.Fallbacks {
+		qs = append(qs, fb.Quality)
+	}
+	return qs
+}
+
+// withDefaults fills in the quality used when none has been configured.
+func (f Feed) withDefaults() Feed {
+	if f.Quality == "" && f.Type.isShowRSS() {
+		if f.Type == FeedTypeShow {
+			f.Quality = QualityFHD
+		} else {
+			f.Quality = QualityAny
+		}
+	}
+	return f
+}
+
+// Subscription is a feed in the subscription registry.
+type Subscription struct {
+	ID        string           `json:"id"`
+	Feed      Feed             `json:"feed"`
+	Paused    bool             `json:"paused"`
+	Notifiers []NotifierConfig `json:"notifiers,omitempty"`
+	Created   time.Time        `json:"created"`
+	Updated   time.Time        `json:"updated"`
+}
+
+// redacted returns s without the secrets of its notifiers, for api
+// responses.
+func (s Subscription) redacted() Subscription {
+	if s.Notifiers != nil {
+		notifiers := make([]NotifierConfig, len(s.Notifiers))
+		for i, c := range s.Notifiers {
+			notifiers[i] = c.redacted()
+		}
+		s.Notifiers = notifiers
+	}
+	return s
+}
+
+func newSubscription(feed Feed) Subscription {
+	feed = feed.withDefaults()
+	now := time.Now()
+	return Subscription{
+		ID:      string(feed.Key()),
+		Feed:    feed,
+		Created: now,
+		Updated: now,
+	}
+}
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+var keySubscriptionsSeeded = []byte("subscriptions_seeded")
+
+// seedSubscriptions adds feeds to the subscription registry unless it has
+// been seeded before.
+func (db *DB) seedSubscriptions(feeds []Feed) (seeded bool, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		bucket := tx.Bucket(bucketSubscriptions)
+		if meta.Get(keySubscriptionsSeeded) != nil {
+			return nil
+		}
+		if k, _ := bucket.Cursor().First(); k != nil {
+			// registry written by a version without the seeded marker
+			return meta.Put(keySubscriptionsSeeded, []byte("1"))
+		}
+		for _, feed := range feeds {
+			if err := putSubscription(bucket, newSubscription(feed)); err != nil {
+				return err
+			}
+		}
+		seeded = true
+		return meta.Put(keySubscriptionsSeeded, []byte("1"))
+	})
+	return seeded, err
+}
+
+// migrateSubscriptions rewrites the subscriptions stored by versions which
+// embedded the feed in the subscription to store the feed under its own key.
+func (db *DB) migrateSubscriptions() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSubscriptions)
+		var migrated []Subscription
+		err := bucket.ForEach(func(k, v []byte) error {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(v, &fields); err != nil {
+				return fmt.Errorf("could not decode subscription '%s': %v", k, err)
+			}
+			if _, ok := fields["feed"]; ok {
+				return nil
+			}
+			var sub Subscription
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return fmt.Errorf("could not decode subscription '%s': %v", k, err)
+			}
+			// the feed id was shadowed by the subscription id, it is
+			// recovered from the subscription id
+			delete(fields, "id")
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &sub.Feed); err != nil {
+				return fmt.Errorf("could not decode feed of subscription '%s': %v", k, err)
+			}
+			sub.ID = string(k)
+			if sub.Feed.Type.isShowRSS() {
+				_, id, _ := strings.Cut(sub.ID, "/")
+				if sub.Feed.ID, err = strconv.Atoi(id); err != nil {
+					return fmt.Errorf("could not recover the feed id of subscription '%s': %v", k, err)
+				}
+			}
+			migrated = append(migrated, sub)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, sub := range migrated {
+			if err := putSubscription(bucket, sub); err != nil {
+				return err
+			}
+		}
+		if len(migrated) > 0 {
+			log.Info().Int("subscriptions", len(migrated)).Msg("migrated subscriptions to store the feed under its own key")
+		}
+		return nil
+	})
+}
+
+func putSubscription(bucket *bolt.Bucket, sub Subscription) error {
+	data, err := json.Marshal(&sub)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(sub.ID), data)
+}
+
+func (db *DB) listSubscriptions() ([]Subscription, error) {
+	var subs []Subscription
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSubscriptions).ForEach(func(k, v []byte) error {
+			var sub Subscription
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return fmt.Errorf("could not decode subscription '%s': %v", k, err)
+			}
+			sub.ID = string(k)
+			subs = append(subs, sub)
+			return nil
+		})
+	})
+	return subs, err
+}
+
+func (db *DB) getSubscription(id string) (Subscription, error) {
+	var sub Subscription
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketSubscriptions).Get([]byte(id))
+		if data == nil {
+			return ErrSubscriptionNotFound
+		}
+		return json.Unmarshal(data, &sub)
+	})
+	sub.ID = id
+	return sub, err
+}
+
+func (db *DB) putSubscription(sub Subscription) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putSubscription(tx.Bucket(bucketSubscriptions), sub)
+	})
+}
+
+func (db *DB) deleteSubscription(id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSubscriptions)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrSubscriptionNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
@@ -0,0 +1,93 @@
+package showrss
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSubscriptionRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	feeds := []Feed{
+		{Type: FeedTypeShow, ID: 123, Quality: QualityHD, Fallbacks: []Fallback{{Quality: QualitySD}}},
+		{Type: FeedTypeUser, ID: 456},
+		{Type: FeedTypeRSS, URL: "https://example.com/feed.xml", ShowName: "Show"},
+	}
+	for _, feed := range feeds {
+		sub := newSubscription(feed)
+		if err := db.putSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.getSubscription(sub.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Feed.ID != feed.ID || got.Feed.Type != feed.Type || got.Feed.URL != feed.URL || got.Feed.Quality != sub.Feed.Quality {
+			t.Errorf("%s: got feed %+v, want %+v", sub.ID, got.Feed, sub.Feed)
+		}
+		if string(got.Feed.Key()) != sub.ID {
+			t.Errorf("%s: got feed key %s", sub.ID, got.Feed.Key())
+		}
+	}
+	subs, err := db.listSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != len(feeds) {
+		t.Fatalf("listed %d subscriptions, want %d", len(subs), len(feeds))
+	}
+	for _, sub := range subs {
+		if string(sub.Feed.Key()) != sub.ID {
+			t.Errorf("%s: listed feed key %s", sub.ID, sub.Feed.Key())
+		}
+	}
+}
+
+func TestMigrateFlatSubscriptions(t *testing.T) {
+	db := newTestDB(t)
+	rows := map[string]string{
+		"show/123":                         `{"id":"show/123","type":"show","quality":"hd","paused":true,"created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+		"rss/https://example.com/feed.xml": `{"id":"rss/https://example.com/feed.xml","type":"rss","url":"https://example.com/feed.xml","paused":false,"created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for k, v := range rows {
+			if err := tx.Bucket(bucketSubscriptions).Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.migrateSubscriptions(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	show, err := db.getSubscription("show/123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show.Feed.Type != FeedTypeShow || show.Feed.ID != 123 || show.Feed.Quality != QualityHD || !show.Paused {
+		t.Errorf("migrated show subscription %+v", show)
+	}
+	rss, err := db.getSubscription("rss/https://example.com/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss.Feed.Type != FeedTypeRSS || rss.Feed.URL != "https://example.com/feed.xml" || rss.Paused {
+		t.Errorf("migrated rss subscription %+v", rss)
+	}
+}
@@ -0,0 +1,55 @@
+package showrss
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pborzenkov/go-transmission/transmission"
+)
+
+// TorrentState is the state of a torrent in transmission.
+type TorrentState struct {
+	Status   string  `json:"status"` // transmission status or "missing"
+	DataDone float64 `json:"data_done"`
+	Error    string  `json:"error,omitempty"`
+}
+
+const torrentStatusMissing = "missing"
+
+// torrentStates returns the transmission state of the torrents with
+// infoHashes, by lower case info hash. Torrents which are not in transmission
+// have the status missing.
+func (d *ShowRSSDownloader) torrentStates(ctx context.Context, infoHashes []string) (map[string]TorrentState, error) {
+	states := make(map[string]TorrentState, len(infoHashes))
+	if len(infoHashes) == 0 {
+		return states, nil
+	}
+	ids := make([]transmission.SingularIdentifier, len(infoHashes))
+	for i, h := range infoHashes {
+		h = strings.ToLower(h)
+		ids[i] = transmission.Hash(h)
+		states[h] = TorrentState{Status: torrentStatusMissing}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	torrents, err := d.TC.GetTorrents(ctx, transmission.IDs(ids...),
+		transmission.TorrentFieldHash,
+		transmission.TorrentFieldStatus,
+		transmission.TorrentFieldDataDone,
+		transmission.TorrentFieldError,
+	)
+	observeRPC("torrent-get", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range torrents {
+		states[strings.ToLower(string(t.Hash))] = TorrentState{
+			Status:   t.Status.String(),
+			DataDone: t.DataDone,
+			Error:    t.Error,
+		}
+	}
+	return states, nil
+}
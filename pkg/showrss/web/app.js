@@ -0,0 +1,222 @@
+"use strict";
+
+const api = "../api/v1";
+
+const tokenKey = "transmission-showrss-token";
+
+async function request(method, path, body, retried) {
+  const opts = { method: method, headers: {} };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const token = localStorage.getItem(tokenKey);
+  if (token) {
+    opts.headers["Authorization"] = "Bearer " + token;
+  }
+  const resp = await fetch(api + path, opts);
+  const challenge = resp.headers.get("WWW-Authenticate") || "";
+  if (resp.status === 401 && challenge.startsWith("Bearer") && !retried) {
+    // basic auth is handled by the browser, tokens are asked for once
+    const stored = localStorage.getItem(tokenKey);
+    const t = stored && stored !== token ? stored : prompt("API token");
+    if (t) {
+      localStorage.setItem(tokenKey, t);
+      return request(method, path, body, true);
+    }
+  }
+  if (!resp.ok) {
+    let msg = resp.status + " " + resp.statusText;
+    try {
+      msg = (await resp.json()).error || msg;
+    } catch (e) {}
+    throw new Error(msg);
+  }
+  if (resp.status === 204 || resp.status === 202) {
+    return null;
+  }
+  return resp.json();
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === "onclick") {
+      e.addEventListener("click", v);
+    } else {
+      e.setAttribute(k, v);
+    }
+  }
+  for (const c of children) {
+    e.append(c === null || c === undefined ? "" : c);
+  }
+  return e;
+}
+
+function fmtTime(s) {
+  if (!s || s.startsWith("0001-")) {
+    return "";
+  }
+  return new Date(s).toLocaleString();
+}
+
+function setStatus(msg, isError) {
+  const s = document.getElementById("status");
+  s.textContent = msg;
+  s.className = isError ? "state-failed" : "";
+}
+
+function action(fn) {
+  return async (ev) => {
+    ev.target.disabled = true;
+    try {
+      await fn();
+      setStatus("ok", false);
+      await load();
+    } catch (e) {
+      setStatus(e.message, true);
+    } finally {
+      ev.target.disabled = false;
+    }
+  };
+}
+
+function renderSubscriptions(subs, statuses) {
+  const byID = new Map(statuses.map((st) => [st.id, st]));
+  const tbody = document.querySelector("#subscriptions tbody");
+  tbody.replaceChildren();
+  for (const sub of subs) {
+    const id = encodeURIComponent(sub.id);
+    const st = byID.get(sub.id) || { sources: [] };
+    const sources = st.sources.length ? st.sources : [{ source: sub.id, state: sub.paused ? "paused" : "pending" }];
+    sources.forEach((src, i) => {
+      const state = sub.paused ? "paused" : src.state;
+      const buttons = i > 0 ? "" : el("td", {},
+        el("button", { onclick: action(() => request("POST", "/subscriptions/" + id + "/poll")) }, "Poll now"),
+        el("button", { onclick: action(() => request("PATCH", "/subscriptions/" + id, { paused: !sub.paused })) },
+          sub.paused ? "Resume" : "Pause"));
+      tbody.append(el("tr", {},
+        el("td", {}, src.source),
+        el("td", { class: "state-" + state }, state),
+        el("td", {}, fmtTime(src.last_success)),
+        el("td", { class: "error" }, src.last_error || ""),
+        el("td", {}, fmtTime(src.state === "failing" ? src.next_retry : src.next_poll)),
+        buttons || el("td")));
+    });
+  }
+}
+
+function renderEpisodes(page) {
+  const tbody = document.querySelector("#episodes tbody");
+  tbody.replaceChildren();
+  for (const item of page.items) {
+    const ep = item.episode;
+    const torrent = item.torrent;
+    let tstate = "";
+    if (torrent) {
+      tstate = torrent.status;
+      if (torrent.status !== "missing") {
+        tstate += " " + Math.round(torrent.data_done * 100) + "%";
+      }
+      if (torrent.error) {
+        tstate += " (" + torrent.error + ")";
+      }
+    }
+    tbody.append(el("tr", {},
+      el("td", {}, fmtTime(item.created)),
+      el("td", {}, ep.show_name),
+      el("td", {}, ep.title),
+      el("td", { class: "state-" + item.state }, item.state),
+      el("td", { class: torrent ? "state-" + torrent.status : "" }, tstate),
+      el("td", {}, el("button", {
+        title: "Forget the episode so that it is added again the next time it shows up in a feed",
+        onclick: action(async () => {
+          if (confirm("Forget " + ep.title + "?")) {
+            await request("DELETE", "/episodes/" + encodeURIComponent(ep.info_hash));
+          }
+        }),
+      }, "Forget"))));
+  }
+  if (page.torrents_error) {
+    setStatus("transmission: " + page.torrents_error, true);
+  }
+}
+
+let subscriptions = [];
+
+async function load() {
+  try {
+    const [subs, statuses, page] = await Promise.all([
+      request("GET", "/subscriptions"),
+      request("GET", "/feeds"),
+      request("GET", "/episodes?limit=50&torrents=true"),
+    ]);
+    subscriptions = subs;
+    renderSubscriptions(subs, statuses);
+    renderEpisodes(page);
+    listen();
+  } catch (e) {
+    setStatus(e.message, true);
+  }
+}
+
+// loadFeeds only reloads the feed states of the subscriptions.
+async function loadFeeds() {
+  try {
+    renderSubscriptions(subscriptions, await request("GET", "/feeds"));
+  } catch (e) {
+    setStatus(e.message, true);
+  }
+}
+
+const timers = {};
+
+// throttle calls fn delay after the first call with name, further calls with
+// the same name until then are dropped.
+function throttle(name, fn, delay) {
+  if (!timers[name]) {
+    timers[name] = setTimeout(() => {
+      timers[name] = null;
+      fn();
+    }, delay);
+  }
+}
+
+let source = null;
+let sourceToken = null;
+
+// listen for downloader events. Episode and alert events reload the page
+// data, polled feeds only the feed states, each at most once per interval
+// however many feeds are polled. Called after each successful load, so that
+// the stream is opened once a token is known and reopened when the token
+// changed or the stream failed.
+function listen() {
+  const token = localStorage.getItem(tokenKey);
+  if (source && source.readyState !== EventSource.CLOSED && token === sourceToken) {
+    return;
+  }
+  if (source) {
+    source.close();
+  }
+  sourceToken = token;
+  const url = api + "/events" + (token ? "?access_token=" + encodeURIComponent(token) : "");
+  source = new EventSource(url);
+  const onEvent = (ev) => {
+    const e = JSON.parse(ev.data);
+    if (e.type === "feed_polled") {
+      throttle("feeds", loadFeeds, 5000);
+      return;
+    }
+    setStatus(e.type.replace("_", " ") + ": " + (e.episode ? e.episode.title : e.reason || e.source || ""),
+      e.type === "episode_failed" || e.type === "alert_firing");
+    throttle("load", load, 1000);
+  };
+  for (const t of ["feed_polled", "episode_filtered", "episode_added", "episode_failed", "episode_completed", "episode_removed",
+    "alert_firing", "alert_resolved"]) {
+    source.addEventListener(t, onEvent);
+  }
+}
+
+document.getElementById("refresh").addEventListener("click", load);
+load();
+setInterval(load, 30000);
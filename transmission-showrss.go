@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
-	"os"
 
 	"github.com/go-pa/fenv"
 	"github.com/pborzenkov/go-transmission/transmission"
@@ -19,6 +17,16 @@ func main() {
 		transmissionConfig = cmdline.TransmissionConfigFlags(flag.CommandLine)
 		feedSelection      = cmdline.FeedSelectionFlags(flag.CommandLine)
 		showDirs           = cmdline.ShowDirsFlags(flag.CommandLine)
+		httpConfig         = cmdline.HTTPConfigFlags(flag.CommandLine)
+		apiConfig          = cmdline.APIConfigFlags(flag.CommandLine)
+		recordConfig       = cmdline.RecordConfigFlags(flag.CommandLine)
+		schedulerConfig    = cmdline.SchedulerConfigFlags(flag.CommandLine)
+		airScheduleConfig  = cmdline.AirScheduleConfigFlags(flag.CommandLine)
+		rankingConfig      = cmdline.RankingConfigFlags(flag.CommandLine)
+		duplicateConfig    = cmdline.DuplicateConfigFlags(flag.CommandLine)
+		notifyConfig       = cmdline.NotifyConfigFlags(flag.CommandLine)
+		digestConfig       = cmdline.DigestConfigFlags(flag.CommandLine)
+		alertConfig        = cmdline.AlertConfigFlags(flag.CommandLine)
 	)
 
 	fenv.CommandLinePrefix("TMTOOL_")
@@ -32,8 +40,7 @@ func main() {
 
 	log.Info().Interface("feed_selection", feedSelection).Msg("config")
 	if feedSelection.IsEmtpy() {
-		fmt.Println("Must choose at least one user or show")
-		os.Exit(1)
+		log.Info().Msg("no feeds selected, using stored subscriptions")
 	}
 
 	tc, err := transmission.New(
@@ -51,17 +58,28 @@ func main() {
 	defer db.Close()
 
 	downloader := showrss.ShowRSSDownloader{
-		ShowDirs:  *showDirs,
-		TC:        tc,
-		DB:        db,
-		Selection: *feedSelection,
+		ShowDirs:    *showDirs,
+		HTTP:        *httpConfig,
+		Record:      *recordConfig,
+		Scheduler:   *schedulerConfig,
+		AirSchedule: *airScheduleConfig,
+		Ranking:     *rankingConfig,
+		Duplicates:  *duplicateConfig,
+		Notify:      *notifyConfig,
+		Digest:      *digestConfig,
+		Alerts:      *alertConfig,
+		TC:          tc,
+		DB:          db,
+		Selection:   *feedSelection,
 	}
 
 	ctx := context.Background()
 	eg, ctx := errgroup.WithContext(ctx)
 
 	eg.Go(func() error { return downloader.Start(ctx) })
-	// eg.Go(func() error { return showrss.APIServer(db, tc, ":8384") })
+	if apiConfig.Addr != "" {
+		eg.Go(func() error { return showrss.APIServer(ctx, &downloader, *apiConfig) })
+	}
 
 	if err := eg.Wait(); err != nil {
 		log.Fatal().Err(err).Msg("exiting")